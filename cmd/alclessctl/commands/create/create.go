@@ -17,17 +17,25 @@
 package create
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/AkihiroSuda/alcless/pkg/brew"
 	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
 	"github.com/AkihiroSuda/alcless/pkg/store"
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
 	"github.com/AkihiroSuda/alcless/pkg/userutil"
+	"github.com/AkihiroSuda/alcless/pkg/workdir"
 )
 
 func New() *cobra.Command {
@@ -41,10 +49,28 @@ func New() *cobra.Command {
 	flags := cmd.Flags()
 	flags.SetInterspersed(false)
 	flags.String("name", "", "Override the instance name")
+	// The limits are saved as the default of `alclessctl shell`
+	cmdutil.AddLimitsFlags(cmd)
+	flags.Bool("record-sessions", false, "record the interactive sessions into ~/.alcless/INSTANCE/recordings by default (See `alclessctl replay`)")
+	flags.String("workdir-mapping", "", "default mapping of the host working directory to the guest working directory (See `alclessctl shell --help`)")
+	flags.StringArray("allow", nil, "restrict the instance to the PROGRAM (absolute path) in the sudoers (can be specified multiple times; \"\" to unrestrict)")
+	flags.String("backend", "", fmt.Sprintf("privilege-switching backend %v (default %q)", sudo.BackendNames(), sudo.DefaultBackend().Name()))
+	flags.Bool("verify-sudoers", false, "verify the content of the installed sudoers file of the existing instance, with the root privilege")
+	flags.String("emit-script", "", "write the privileged commands into a shell script FILE (\"-\" for stdout) instead of executing them")
 
 	return cmd
 }
 
+// ValidateTemplate validates the template locator, e.g., "template://default".
+func ValidateTemplate(locator string) error {
+	switch locator {
+	case "template://default":
+		return nil
+	default:
+		return fmt.Errorf("unknown template: %q (currently, only template://default is available)", locator)
+	}
+}
+
 func resolveInstName(args0, flagName string) (string, error) {
 	instName := "default"
 	if flagName != "" {
@@ -55,12 +81,10 @@ func resolveInstName(args0, flagName string) (string, error) {
 	}
 	if args0 != "" {
 		if strings.HasPrefix(args0, "template://") {
-			switch args0 {
-			case "template://default":
-				return instName, nil
-			default:
-				return "", fmt.Errorf("unknown template: %q (currently, only template://default is available)", args0)
+			if err := ValidateTemplate(args0); err != nil {
+				return "", err
 			}
+			return instName, nil
 		}
 		if args0 != "" && flagName != "" && args0 != flagName {
 			return "", fmt.Errorf("instance name %q and CLI flag --name=%q cannot be specified together",
@@ -72,16 +96,7 @@ func resolveInstName(args0, flagName string) (string, error) {
 }
 
 func action(cmd *cobra.Command, args []string) error {
-	ctx := cmd.Context()
 	flags := cmd.Flags()
-	flagTty, err := flags.GetBool("tty")
-	if err != nil {
-		return err
-	}
-	flagPlain, err := flags.GetBool("plain")
-	if err != nil {
-		return err
-	}
 	flagName, err := flags.GetString("name")
 	if err != nil {
 		return err
@@ -97,37 +112,379 @@ func action(cmd *cobra.Command, args []string) error {
 	if err = store.ValidateName(instName); err != nil {
 		return err
 	}
+	opts, err := OptionsFromCobra(cmd)
+	if err != nil {
+		return err
+	}
+	if opts.VerifySudoers, err = flags.GetBool("verify-sudoers"); err != nil {
+		return err
+	}
+	if err = sudoersFromCobra(cmd, opts); err != nil {
+		return err
+	}
+	if opts.config, err = configFromCobra(cmd, instName, opts); err != nil {
+		return err
+	}
+	flagEmitScript, err := flags.GetString("emit-script")
+	if err != nil {
+		return err
+	}
+	if flagEmitScript != "" {
+		return emitScript(cmd, instName, flagEmitScript, opts)
+	}
+	return Create(cmd, instName, opts)
+}
+
+// emitScript writes the privileged commands for creating the instance into a script,
+// so that the script can be reviewed and executed by an administrator.
+// `alclessctl create` detects the completed setup afterward.
+func emitScript(cmd *cobra.Command, instName, scriptPath string, opts *Options) error {
+	ctx := cmd.Context()
+	instUser := userutil.UserFromInstance(instName)
+	instCfg := opts.config
+	if instCfg == nil {
+		var err error
+		if instCfg, err = store.LoadConfig(instName); err != nil {
+			return err
+		}
+	} else if err := updateConfig(ctx, instName, instCfg); err != nil {
+		// Saved before the user is created, as `alclessctl create` loads the config after the script is executed
+		return err
+	}
+	backend, err := instCfg.SudoBackend()
+	if err != nil {
+		return err
+	}
+	// The script is expected to be executed on a terminal, where sysadminctl can prompt the password
+	steps, err := userutil.AddUserSteps(ctx, instUser, true, &instCfg.Sudoers, backend)
+	if err != nil {
+		return err
+	}
+	comment := fmt.Sprintf(`Generated by alclessctl create --emit-script for the instance %q.
+
+This script creates the user %q, and allows the user %q
+to run commands as the user without a password.
+Run this script as root, and then run "alclessctl create %s" as %q to finish the setup.`,
+		instName, instUser, userutil.Me(), instName, userutil.Me())
+	if err = cmdutil.WriteScriptFile(cmd.OutOrStdout(), scriptPath, comment, steps); err != nil {
+		return err
+	}
+	if scriptPath != "-" {
+		slog.InfoContext(ctx, "Wrote the script. Run the script as root, and then run `alclessctl create` again to finish the setup.",
+			"script", scriptPath, "instance", instName)
+	}
+	return nil
+}
+
+// Options are the options of [Create].
+type Options struct {
+	// Tty specifies whether the password can be prompted.
+	Tty bool
+	// Plain skips the installation of Homebrew.
+	Plain bool
+	// VerifySudoers verifies the installed sudoers file of the existing instance.
+	VerifySudoers bool
+	// Sudoers replaces the sudoers policy of the instance, if non-nil.
+	Sudoers *sudo.Policy
+	// Backend replaces the privilege-switching backend of the instance, if non-empty.
+	Backend string
+
+	// config is the config updated with the flags of `alclessctl create`.
+	config *store.Config
+}
+
+// OptionsFromCobra returns the options from the global flags (--tty and --plain).
+// The other fields are left to the caller, as the flags of `alclessctl create`
+// are not defined for the other commands that create an instance.
+func OptionsFromCobra(cmd *cobra.Command) (*Options, error) {
+	flags := cmd.Flags()
+	var (
+		opts Options
+		err  error
+	)
+	if opts.Tty, err = flags.GetBool("tty"); err != nil {
+		return nil, err
+	}
+	if opts.Plain, err = flags.GetBool("plain"); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+// apply applies opts.Sudoers and opts.Backend to instCfg.
+func (opts *Options) apply(instCfg *store.Config) {
+	if opts.Sudoers != nil {
+		instCfg.Sudoers = *opts.Sudoers
+	}
+	if opts.Backend != "" {
+		instCfg.Backend = opts.Backend
+	}
+}
+
+// Create creates the instance, and installs Homebrew unless opts.Plain is set.
+// Nothing is done for the existing parts of the instance.
+func Create(cmd *cobra.Command, instName string, opts *Options) error {
+	ctx := cmd.Context()
 	instUser := userutil.UserFromInstance(instName)
 	instUserExists, err := userutil.Exists(instUser)
 	if err != nil {
 		return err
 	}
+	dryRun := cmdutil.IsDryRun(ctx)
+	setup, err := store.LoadSetupState(instName)
+	if err != nil {
+		return err
+	}
+	savedCfg, err := store.LoadConfig(instName)
+	if err != nil {
+		return err
+	}
+	instCfg := opts.config
+	if instCfg == nil {
+		cfgCopy := *savedCfg
+		instCfg = &cfgCopy
+	}
+	opts.apply(instCfg)
+	if !instUserExists && !opts.Plain && allowsHomebrew(&instCfg.Sudoers) {
+		// The installer needs sh and git, which are not allowed in the restricted mode
+		return fmt.Errorf("cannot install Homebrew in the restricted sudoers mode (Hint: run `alclessctl create %s` without --allow first, and then run `alclessctl create --allow=... %s`)",
+			instName, instName)
+	}
+	if !instUserExists && instCfg.Backend == "" {
+		// Record the backend, as the default may change in future
+		instCfg.Backend = sudo.DefaultBackend().Name()
+	}
+	// The config of a new instance is saved after the user is created,
+	// so that a failed creation does not affect the next creation.
+	saveConfig := func() error {
+		if reflect.DeepEqual(savedCfg, instCfg) {
+			return nil
+		}
+		return updateConfig(ctx, instName, instCfg)
+	}
 	if instUserExists {
-		slog.InfoContext(ctx, "Already exists", "instance", instName, "instUser", instUser)
-	} else {
+		if err = saveConfig(); err != nil {
+			return err
+		}
+	}
+	sudoersPolicy := &instCfg.Sudoers
+	backend, err := instCfg.SudoBackend()
+	if err != nil {
+		return err
+	}
+	// The password is not needed when the user already exists
+	steps, err := userutil.AddUserSteps(ctx, instUser, opts.Tty || instUserExists, sudoersPolicy, backend)
+	if err != nil {
+		return err
+	}
+	start := 0
+	switch {
+	case !instUserExists:
 		slog.InfoContext(ctx, "Creating an instance", "instance", instName, "instUser", instUser)
-		cmds, err := userutil.AddUserCmds(ctx, instUser, flagTty)
+	case setup != nil:
+		// The user has been created, so the first step is never resumed
+		start = max(setup.Completed, 1)
+		slog.InfoContext(ctx, "Resuming the incomplete setup", "instance", instName, "instUser", instUser, "failed", setup.Failed)
+	default:
+		slog.InfoContext(ctx, "Already exists", "instance", instName, "instUser", instUser)
+		start = len(steps)
+		if opts.Sudoers != nil || opts.Backend != "" {
+			// Reinstall the sudoers file. The steps except the first one are idempotent.
+			slog.InfoContext(ctx, "Updating the sudoers", "instance", instName, "policy", sudoersPolicy.String(), "backend", backend.Name())
+			start = 1
+		} else if !dryRun {
+			// The setup may have been completed externally with `alclessctl create --emit-script`
+			err = userutil.CheckSetup(ctx, instUser, sudoersPolicy, backend)
+			if err == nil && opts.VerifySudoers {
+				err = verifySudoers(cmd, instUser, sudoersPolicy, backend)
+			}
+			if err != nil {
+				// The steps except the first one are idempotent
+				slog.WarnContext(ctx, "The setup of the user is incomplete, retrying", "instance", instName, "instUser", instUser, "error", err)
+				start = 1
+			} else {
+				slog.InfoContext(ctx, "The setup of the user has been completed", "instance", instName, "instUser", instUser)
+			}
+		}
+	}
+	if start < len(steps) {
+		runOpts, err := cmdutil.RunOptsFromCobra(cmd)
 		if err != nil {
 			return err
 		}
-		if err := cmdutil.RunWithCobra(ctx, cmds, cmd); err != nil {
+		// The privileged steps are batched into a single sudo invocation
+		if err := cmdutil.RunSteps(ctx, steps[start:], runOpts); err != nil {
+			var stepErr *cmdutil.StepError
+			if errors.As(err, &stepErr) {
+				if completed := start + stepErr.Completed; completed > 0 {
+					if saveErr := saveConfig(); saveErr != nil {
+						err = errors.Join(err, saveErr)
+					}
+					return recordIncomplete(ctx, instName, completed, stepErr.Steps[stepErr.Completed].Description, err)
+				}
+			}
 			return err
 		}
 	}
-	if !flagPlain {
-		if err = brew.Installed(ctx, instUser); err == nil {
+	if !instUserExists {
+		if err = saveConfig(); err != nil {
+			return err
+		}
+	}
+	if !opts.Plain && sudoersPolicy.Restricted() {
+		// The installer needs sh and git
+		slog.InfoContext(ctx, "Skipping the installation of Homebrew in the restricted sudoers mode (Hint: create the instance without --allow first)", "instance", instName)
+	} else if !opts.Plain {
+		if err = brew.Installed(ctx, instUser, instCfg.SudoOpts()...); err == nil {
 			slog.InfoContext(ctx, "Homebrew is already installed", "instance", instName, "instUser", instUser)
 		} else {
 			slog.DebugContext(ctx, "Homebrew is not installed", "instance", instName, "instUser", instUser, "error", err)
 			slog.InfoContext(ctx, "Installing Homebrew (If you are seeing an error, do NOT report it to the upstream Homebrew)", "instance", instName, "instUser", instUser)
-			cmds := brew.InstallCmds(ctx, instUser)
+			cmds := brew.InstallCmds(ctx, instUser, instCfg.SudoOpts()...)
 			if err = cmdutil.RunWithCobra(ctx, cmds, cmd); err != nil {
-				return err
+				return recordIncomplete(ctx, instName, len(steps), installHomebrew, err)
 			}
-			if err = brew.Installed(ctx, instUser); err != nil {
-				return fmt.Errorf("failed to detect Homebrew: %w", err)
+			if dryRun {
+				return nil
+			}
+			if err = brew.Installed(ctx, instUser, instCfg.SudoOpts()...); err != nil {
+				return recordIncomplete(ctx, instName, len(steps), installHomebrew, fmt.Errorf("failed to detect Homebrew: %w", err))
 			}
 		}
 	}
+	if dryRun {
+		return nil
+	}
+	return store.RemoveSetupState(instName)
+}
+
+// allowsHomebrew returns true if the restricted sudoers policy allows the brew command.
+func allowsHomebrew(policy *sudo.Policy) bool {
+	if !policy.Restricted() {
+		return false
+	}
+	return slices.ContainsFunc(policy.Allow, func(f string) bool { return filepath.Base(f) == "brew" })
+}
+
+// verifySudoers verifies the installed sudoers file with the root privilege,
+// as the file is not readable by the current user.
+func verifySudoers(cmd *cobra.Command, instUser string, policy *sudo.Policy, backend sudo.Backend) error {
+	ctx := cmd.Context()
+	verifyCmd, err := sudo.VerifySudoersCmd(ctx, instUser, policy, backend)
+	if err != nil {
+		return err
+	}
+	// Not destructive, no confirmation
+	opts, err := cmdutil.RunOptsFromCobraNoStdin(cmd)
+	if err != nil {
+		return err
+	}
+	if err = cmdutil.Run(ctx, []*exec.Cmd{verifyCmd}, opts); err != nil {
+		return fmt.Errorf("%w: %w", sudo.ErrSudoersDrift, err)
+	}
+	return nil
+}
+
+// installHomebrew is the description of the step that follows the privileged steps.
+const installHomebrew = "Install Homebrew"
+
+// recordIncomplete records the instance as incomplete, so that the next `alclessctl create`
+// resumes from the failed step, and `alclessctl list` shows the broken state.
+// The returned error wraps cause.
+func recordIncomplete(ctx context.Context, instName string, completed int, failed string, cause error) error {
+	if cmdutil.IsDryRun(ctx) {
+		return cause
+	}
+	st := &store.SetupState{
+		Completed: completed,
+		Failed:    failed,
+		Error:     cause.Error(),
+		Updated:   time.Now(),
+	}
+	if err := store.SaveSetupState(instName, st); err != nil {
+		return errors.Join(cause, err)
+	}
+	slog.WarnContext(ctx, "Recorded the instance as incomplete", "instance", instName, "failed", failed)
+	return fmt.Errorf("%w (Hint: run `alclessctl create %s` again to resume the setup)", cause, instName)
+}
+
+// sudoersFromCobra sets opts.Sudoers and opts.Backend from --allow and --backend.
+func sudoersFromCobra(cmd *cobra.Command, opts *Options) error {
+	flags := cmd.Flags()
+	if flags.Changed("allow") {
+		flagAllow, err := flags.GetStringArray("allow")
+		if err != nil {
+			return err
+		}
+		var policy sudo.Policy
+		for _, f := range flagAllow {
+			if f != "" {
+				policy.Allow = append(policy.Allow, f)
+			}
+		}
+		if err = policy.Validate(); err != nil {
+			return err
+		}
+		opts.Sudoers = &policy
+	}
+	if flags.Changed("backend") {
+		flagBackend, err := flags.GetString("backend")
+		if err != nil {
+			return err
+		}
+		backend, err := sudo.BackendByName(flagBackend)
+		if err != nil {
+			return err
+		}
+		opts.Backend = backend.Name()
+	}
+	return nil
+}
+
+// configFromCobra returns the config of the instance updated with the flags.
+// Nil is returned if no flag is specified.
+func configFromCobra(cmd *cobra.Command, instName string, opts *Options) (*store.Config, error) {
+	flags := cmd.Flags()
+	if !flags.Changed("ulimit") && !flags.Changed("nice") && !flags.Changed("background") && !flags.Changed("record-sessions") && !flags.Changed("workdir-mapping") && opts.Sudoers == nil && opts.Backend == "" {
+		return nil, nil
+	}
+	instCfg, err := store.LoadConfig(instName)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := cmdutil.LimitsFromCobra(cmd, instCfg.Limits)
+	if err != nil {
+		return nil, err
+	}
+	instCfg.Limits = *limits
+	if flags.Changed("record-sessions") {
+		if instCfg.Record, err = flags.GetBool("record-sessions"); err != nil {
+			return nil, err
+		}
+	}
+	if flags.Changed("workdir-mapping") {
+		flagWorkdirMapping, err := flags.GetString("workdir-mapping")
+		if err != nil {
+			return nil, err
+		}
+		instCfg.WorkdirMapping = workdir.Policy(flagWorkdirMapping)
+		if err = instCfg.WorkdirMapping.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	opts.apply(instCfg)
+	return instCfg, nil
+}
+
+// updateConfig saves the config of the instance, except in the dry-run mode.
+func updateConfig(ctx context.Context, instName string, instCfg *store.Config) error {
+	if cmdutil.IsDryRun(ctx) {
+		slog.InfoContext(ctx, "Not saving the config (dry run)", "instance", instName)
+		return nil
+	}
+	if err := store.SaveConfig(instName, instCfg); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Saved the config", "instance", instName, "limits", instCfg.Limits, "recordSessions", instCfg.Record, "workdirMapping", instCfg.WorkdirMapping, "sudoers", instCfg.Sudoers.String(), "backend", instCfg.Backend)
 	return nil
 }
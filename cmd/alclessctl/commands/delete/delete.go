@@ -17,11 +17,16 @@
 package delete
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"os/exec"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/procutil"
 	"github.com/AkihiroSuda/alcless/pkg/store"
 	"github.com/AkihiroSuda/alcless/pkg/userutil"
 )
@@ -37,17 +42,60 @@ func New() *cobra.Command {
 	}
 	flags := cmd.Flags()
 	flags.Bool("secure", false, "securely delete instance data (slow)")
+	flags.String("emit-script", "", "write the privileged commands into a shell script FILE (\"-\" for stdout) instead of executing them")
 	return cmd
 }
 
 func action(cmd *cobra.Command, args []string) error {
-	ctx := cmd.Context()
 	flags := cmd.Flags()
 	flagSecure, err := flags.GetBool("secure")
 	if err != nil {
 		return err
 	}
-	instName := args[0]
+	flagEmitScript, err := flags.GetString("emit-script")
+	if err != nil {
+		return err
+	}
+	if flagEmitScript != "" {
+		return emitScript(cmd, args[0], flagSecure, flagEmitScript)
+	}
+	opts, err := cmdutil.RunOptsFromCobra(cmd)
+	if err != nil {
+		return err
+	}
+	return Delete(cmd.Context(), args[0], flagSecure, opts)
+}
+
+// emitScript writes the privileged commands for deleting the instance into a script,
+// so that the script can be reviewed and executed by an administrator.
+func emitScript(cmd *cobra.Command, instName string, secure bool, scriptPath string) error {
+	ctx := cmd.Context()
+	if err := store.ValidateName(instName); err != nil {
+		return err
+	}
+	instUser := userutil.UserFromInstance(instName)
+	steps, err := userutil.DeleteUserSteps(ctx, instUser, secure)
+	if err != nil {
+		return err
+	}
+	comment := fmt.Sprintf(`Generated by alclessctl delete --emit-script for the instance %q.
+
+This script deletes the user %q and the sudoers file.
+Run this script as root, and then run "alclessctl delete %s" as %q to remove the instance directory.`,
+		instName, instUser, instName, userutil.Me())
+	if err = cmdutil.WriteScriptFile(cmd.OutOrStdout(), scriptPath, comment, steps); err != nil {
+		return err
+	}
+	if scriptPath != "-" {
+		slog.InfoContext(ctx, "Wrote the script. Run the script as root, and then run `alclessctl delete` again to finish the deletion.",
+			"script", scriptPath, "instance", instName)
+	}
+	return nil
+}
+
+// Delete deletes the instance user and the instance directory on the host.
+// The privileged commands are executed with opts.
+func Delete(ctx context.Context, instName string, secure bool, opts *cmdutil.RunOpts) error {
 	if err := store.ValidateName(instName); err != nil {
 		return err
 	}
@@ -58,8 +106,54 @@ func action(cmd *cobra.Command, args []string) error {
 	}
 	if !instUserExists {
 		slog.WarnContext(ctx, "No such instance", "instance", instName, "instUser", instUser)
+		if cmdutil.IsDryRun(ctx) {
+			return nil
+		}
+		return store.RemoveInstanceDir(instName)
+	}
+	// The processes (e.g., the children of su left behind when sudo was killed by a signal) are terminated first,
+	// as in `alclessctl stop`
+	if err = terminateProcesses(ctx, instName, instUser); err != nil {
+		return err
+	}
+	steps, err := userutil.DeleteUserSteps(ctx, instUser, secure)
+	if err != nil {
+		return err
+	}
+	if err = cmdutil.RunSteps(ctx, steps, opts); err != nil {
+		return err
+	}
+	if cmdutil.IsDryRun(ctx) {
 		return nil
 	}
-	cmds, err := userutil.DeleteUserCmds(ctx, instUser, flagSecure)
-	return cmdutil.RunWithCobra(ctx, cmds, cmd)
+	return store.RemoveInstanceDir(instName)
+}
+
+// terminateTimeout is the duration to wait for the processes to exit after SIGTERM.
+const terminateTimeout = 10 * time.Second
+
+// terminateProcesses terminates all the processes of instUser.
+func terminateProcesses(ctx context.Context, instName, instUser string) error {
+	procs, err := userutil.Processes(ctx, instUser)
+	if err != nil || len(procs) == 0 {
+		return err
+	}
+	instCfg, err := store.LoadConfig(instName)
+	if err != nil {
+		return err
+	}
+	for _, p := range procs {
+		slog.InfoContext(ctx, "Terminating a process", "instance", instName, "pid", p.PID, "command", p.Command)
+	}
+	if cmdutil.IsDryRun(ctx) {
+		return cmdutil.Run(ctx, []*exec.Cmd{procutil.KillCmd(ctx, instUser, "TERM", procs, instCfg.SudoOpts()...)}, nil)
+	}
+	remaining, err := procutil.Terminate(ctx, instUser, procs, terminateTimeout, instCfg.SudoOpts()...)
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("%d process(es) of instance %q are still running (Hint: run `alclessctl stop %s`)", len(remaining), instName, instName)
+	}
+	return nil
 }
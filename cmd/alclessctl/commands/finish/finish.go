@@ -0,0 +1,112 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package finish
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/shell"
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/errdefs"
+	"github.com/AkihiroSuda/alcless/pkg/session"
+	"github.com/AkihiroSuda/alcless/pkg/store"
+)
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "finish SESSION",
+		Short: "Finish a detached session",
+		Long: `Finish a detached session that has exited.
+The files are synced back to the host with the same review as ` + "`alclessctl shell`" + `, and the session is removed.`,
+		Args:                  cobra.ExactArgs(1),
+		RunE:                  action,
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+func action(cmd *cobra.Command, args []string) error {
+	s, err := session.Load(args[0])
+	if err != nil {
+		return err
+	}
+	return Finish(cmd, s)
+}
+
+// Finish kills the leftover processes if requested, syncs back the files, and removes the session.
+func Finish(cmd *cobra.Command, s *session.Session) error {
+	ctx := cmd.Context()
+	st, exitCode, err := s.Status()
+	if err != nil {
+		return err
+	}
+	switch st {
+	case session.StatusRunning:
+		return fmt.Errorf("session %q is still running (Hint: wait for the command to exit, or run `alclessctl stop %s`)", s.Name, s.Instance)
+	case session.StatusUnknown:
+		slog.WarnContext(ctx, "The exit code of the session is unknown", "session", s.Name)
+	}
+	instCfg, err := store.LoadConfig(s.Instance)
+	if err != nil {
+		return err
+	}
+	var cmdErr error
+	if exitCode != 0 {
+		cmdErr = &errdefs.CommandError{
+			Err:  fmt.Errorf("the command of session %q exited with status %d", s.Name, exitCode),
+			Code: exitCode,
+		}
+	}
+	var killErr error
+	switch {
+	case s.KillLeftovers && s.SessionID == "":
+		// An empty session ID would kill all the processes of the instance, including the other sessions
+		slog.WarnContext(ctx, "Not killing the leftover processes, as the session ID is not recorded", "session", s.Name)
+	case s.KillLeftovers:
+		// The files are still synced back on an error, as in `alclessctl shell`
+		if killErr = shell.KillLeftovers(ctx, s.User, s.SessionID, instCfg.SudoOpts()...); killErr != nil {
+			slog.ErrorContext(ctx, killErr.Error())
+		}
+	}
+	if !s.Plain && !s.ReadOnly {
+		if _, err = shell.SyncBack(ctx, cmd, s.Instance, s.GuestWD, s.HostWD, cmdErr); err != nil {
+			return errors.Join(err, killErr)
+		}
+	}
+	if killErr != nil {
+		// The session is kept, so that the leftover processes can be killed by running `alclessctl finish` again
+		// The exit code of the command takes precedence over the exit code of killErr
+		return errors.Join(cmdErr, killErr)
+	}
+	if s.FreshDir != "" {
+		if err = shell.RemoveFreshDir(ctx, s.User, s.FreshDir, instCfg.SudoOpts()...); err != nil {
+			return err
+		}
+	}
+	if cmdutil.IsDryRun(ctx) {
+		return cmdErr
+	}
+	if err = session.Remove(s.Name); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Finished the session", "session", s.Name)
+	return cmdErr
+}
@@ -79,9 +79,14 @@ func action(cmd *cobra.Command, args []string) error {
 		}
 	default:
 		w := tabwriter.NewWriter(stdout, 4, 8, 4, ' ', 0)
-		fmt.Fprintln(w, "NAME\tUSER")
+		fmt.Fprintln(w, "NAME\tUSER\tSTATUS\tSUDOERS")
 		for _, b := range insts {
-			if _, err = fmt.Fprintf(w, "%s\t%s\n", b.Name, b.User); err != nil {
+			status := "Ready"
+			if b.Setup != nil {
+				// e.g., "Incomplete (failed: Install Homebrew)"
+				status = fmt.Sprintf("Incomplete (failed: %s)", b.Setup.Failed)
+			}
+			if _, err = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.Name, b.User, status, b.Sudoers.String()); err != nil {
 				return err
 			}
 		}
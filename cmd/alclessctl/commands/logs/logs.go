@@ -0,0 +1,78 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package logs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/finish"
+	"github.com/AkihiroSuda/alcless/pkg/session"
+)
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "logs SESSION",
+		Short:                 "Show the output of a detached session",
+		Args:                  cobra.ExactArgs(1),
+		RunE:                  action,
+		DisableFlagsInUseLine: true,
+	}
+	flags := cmd.Flags()
+	flags.BoolP("follow", "f", false, "follow the output until the session exits")
+	flags.Bool("finish", false, "finish the session (i.e., sync back the files) after it exits, as with the finish command (requires --follow). Ctrl-C stops following without finishing the session")
+	return cmd
+}
+
+func action(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	flags := cmd.Flags()
+	flagFollow, err := flags.GetBool("follow")
+	if err != nil {
+		return err
+	}
+	flagFinish, err := flags.GetBool("finish")
+	if err != nil {
+		return err
+	}
+	if flagFinish && !flagFollow {
+		return errors.New("option --finish requires option --follow")
+	}
+	s, err := session.Load(args[0])
+	if err != nil {
+		return err
+	}
+	if !flagFinish {
+		return s.Follow(ctx, cmd.OutOrStdout(), flagFollow)
+	}
+	followCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	err = s.Follow(followCtx, cmd.OutOrStdout(), true)
+	stop()
+	if err != nil {
+		if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+			slog.InfoContext(ctx, "Stopped following the session without finishing it", "session", s.Name)
+			return nil
+		}
+		return err
+	}
+	return finish.Finish(cmd, s)
+}
@@ -0,0 +1,67 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package pool
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	instpool "github.com/AkihiroSuda/alcless/pkg/pool"
+)
+
+func newDrainCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "drain",
+		Short:                 "Set the size of the pool to 0, and delete the available instances",
+		Args:                  cobra.NoArgs,
+		RunE:                  drainAction,
+		DisableFlagsInUseLine: true,
+	}
+	flags := cmd.Flags()
+	flags.Bool("all", false, "delete the leased instances and the instances left in the creating or deleting state too")
+	return cmd
+}
+
+func drainAction(cmd *cobra.Command, args []string) error {
+	if err := checkNotDryRun(cmd); err != nil {
+		return err
+	}
+	flagAll, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+	var stale []string
+	if err = instpool.Update(func(st *instpool.State) error {
+		st.Size = 0
+		if flagAll {
+			for _, m := range st.Members {
+				if m.State != instpool.MemberAvailable {
+					stale = append(stale, m.Instance)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	errs := []error{Fill(cmd)}
+	for _, instName := range stale {
+		errs = append(errs, deleteMember(cmd, instName))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,117 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/shell"
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	instpool "github.com/AkihiroSuda/alcless/pkg/pool"
+	"github.com/AkihiroSuda/alcless/pkg/store"
+	"github.com/AkihiroSuda/alcless/pkg/userutil"
+)
+
+func newLeaseCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "lease",
+		Short:                 "Lease an available instance, and print its name",
+		Long:                  "Lease an available instance, and print its name. The instance has to be returned with `alclessctl pool return`.",
+		Args:                  cobra.NoArgs,
+		RunE:                  leaseAction,
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+func leaseAction(cmd *cobra.Command, args []string) error {
+	if err := checkNotDryRun(cmd); err != nil {
+		return err
+	}
+	instName, err := instpool.Lease()
+	if err != nil {
+		if errors.Is(err, instpool.ErrNoAvailable) {
+			return fmt.Errorf("%w (Hint: run `alclessctl pool size N`)", err)
+		}
+		return err
+	}
+	_, err = fmt.Fprintln(cmd.OutOrStdout(), instName)
+	return err
+}
+
+func newReturnCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "return INSTANCE",
+		Short:                 "Return a leased instance, and refill the pool",
+		Long:                  "Return a leased instance, and refill the pool. The instance is deleted unless --recycle is specified.",
+		Args:                  cobra.ExactArgs(1),
+		RunE:                  returnAction,
+		DisableFlagsInUseLine: true,
+	}
+	flags := cmd.Flags()
+	flags.Bool("recycle", false, "put the instance back to the pool without deleting it (the files and the installed packages are kept)")
+	flags.Bool("no-refill", false, "do not refill the pool")
+	return cmd
+}
+
+func returnAction(cmd *cobra.Command, args []string) error {
+	if err := checkNotDryRun(cmd); err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	flags := cmd.Flags()
+	flagRecycle, err := flags.GetBool("recycle")
+	if err != nil {
+		return err
+	}
+	flagNoRefill, err := flags.GetBool("no-refill")
+	if err != nil {
+		return err
+	}
+	instName := args[0]
+	if flagRecycle {
+		instCfg, err := store.LoadConfig(instName)
+		if err != nil {
+			return err
+		}
+		// Processes must not survive across the leases.
+		// They are killed only after verifying that the instance is leased from the pool.
+		killAll := func() error {
+			return shell.KillLeftovers(ctx, userutil.UserFromInstance(instName), "", instCfg.SudoOpts()...)
+		}
+		if err = instpool.Return(instName, false, killAll); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Recycled", "instance", instName)
+	} else {
+		opts, err := cmdutil.RunOptsFromCobra(cmd)
+		if err != nil {
+			return err
+		}
+		if err = Wipe(ctx, instName, opts); err != nil {
+			return err
+		}
+	}
+	if flagNoRefill {
+		return nil
+	}
+	return Fill(cmd)
+}
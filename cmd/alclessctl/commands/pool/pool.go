@@ -0,0 +1,128 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pool implements `alclessctl pool`.
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/create"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/delete"
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	instpool "github.com/AkihiroSuda/alcless/pkg/pool"
+)
+
+const example = `
+  Keep 3 instances ready:
+  $ alclessctl pool size 3
+
+  Run a command in a pristine instance, and wipe it after the command exits:
+  $ alclessctl run --pool make test
+
+  Lease an instance manually:
+  $ INST=$(alclessctl pool lease)
+  $ alclessctl shell $INST make test
+  $ alclessctl pool return $INST
+
+  Delete the available instances:
+  $ alclessctl pool drain`
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "pool",
+		Short:   "Manage the pool of pre-created instances",
+		Example: example,
+		Args:    cobra.NoArgs,
+	}
+	cmd.AddCommand(
+		newSizeCommand(),
+		newStatusCommand(),
+		newDrainCommand(),
+		newLeaseCommand(),
+		newReturnCommand(),
+	)
+	return cmd
+}
+
+// checkNotDryRun returns an error in the dry-run mode, as the state of the pool cannot be planned without being updated.
+func checkNotDryRun(cmd *cobra.Command) error {
+	if cmdutil.IsDryRun(cmd.Context()) {
+		return fmt.Errorf("`%s` does not support --dry-run", cmd.CommandPath())
+	}
+	return nil
+}
+
+// Fill creates and deletes the instances so that the configured number of instances are available.
+func Fill(cmd *cobra.Command) error {
+	ctx := cmd.Context()
+	st, err := instpool.Load()
+	if err != nil {
+		return err
+	}
+	toCreate, toDelete, err := instpool.Plan()
+	if err != nil {
+		return err
+	}
+	createOpts, err := create.OptionsFromCobra(cmd)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, instName := range toDelete {
+		errs = append(errs, deleteMember(cmd, instName))
+	}
+	for _, instName := range toCreate {
+		slog.InfoContext(ctx, "Creating an instance for the pool", "instance", instName, "template", st.Template)
+		if err = create.Create(cmd, instName, createOpts); err != nil {
+			errs = append(errs, fmt.Errorf("failed to create instance %q: %w", instName, err))
+			// Delete the partially created instance
+			errs = append(errs, deleteMember(cmd, instName))
+			continue
+		}
+		errs = append(errs, instpool.SetState(instName, instpool.MemberAvailable))
+	}
+	return errors.Join(errs...)
+}
+
+// deleteMember deletes the instance and removes it from the pool.
+func deleteMember(cmd *cobra.Command, instName string) error {
+	slog.InfoContext(cmd.Context(), "Deleting an instance of the pool", "instance", instName)
+	opts, err := cmdutil.RunOptsFromCobra(cmd)
+	if err != nil {
+		return err
+	}
+	if err = delete.Delete(cmd.Context(), instName, false, opts); err != nil {
+		return fmt.Errorf("failed to delete instance %q: %w", instName, err)
+	}
+	return instpool.Forget(instName)
+}
+
+// Wipe deletes the leased instance, and removes it from the pool.
+func Wipe(ctx context.Context, instName string, opts *cmdutil.RunOpts) error {
+	if err := instpool.Return(instName, true, nil); err != nil {
+		return err
+	}
+	if err := delete.Delete(ctx, instName, false, opts); err != nil {
+		return fmt.Errorf("failed to delete instance %q: %w", instName, err)
+	}
+	return instpool.Forget(instName)
+}
@@ -0,0 +1,84 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package pool
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/create"
+	instpool "github.com/AkihiroSuda/alcless/pkg/pool"
+)
+
+func newSizeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "size [N]",
+		Short:                 "Show or set the number of the instances to keep available, and fill the pool",
+		Args:                  cobra.MaximumNArgs(1),
+		RunE:                  sizeAction,
+		DisableFlagsInUseLine: true,
+	}
+	flags := cmd.Flags()
+	flags.String("template", "template://default", "template of the instances")
+	return cmd
+}
+
+func sizeAction(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	if len(args) > 0 {
+		if err := checkNotDryRun(cmd); err != nil {
+			return err
+		}
+	}
+	if len(args) == 0 {
+		st, err := instpool.Load()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), st.Size)
+		return err
+	}
+	size, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		return fmt.Errorf("expected a non-negative size, got %d", size)
+	}
+	flagTemplate, err := cmd.Flags().GetString("template")
+	if err != nil {
+		return err
+	}
+	if err = create.ValidateTemplate(flagTemplate); err != nil {
+		return err
+	}
+	if err = instpool.Update(func(st *instpool.State) error {
+		if st.Template != "" && st.Template != flagTemplate && st.Count(instpool.MemberAvailable) > 0 {
+			slog.WarnContext(ctx, "The template was changed; run `alclessctl pool drain` to delete the available instances created from the old template",
+				"old", st.Template, "new", flagTemplate)
+		}
+		st.Size = size
+		st.Template = flagTemplate
+		return nil
+	}); err != nil {
+		return err
+	}
+	return Fill(cmd)
+}
@@ -0,0 +1,78 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package pool
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	instpool "github.com/AkihiroSuda/alcless/pkg/pool"
+)
+
+func newStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "status",
+		Short:                 "Show the status of the pool",
+		Args:                  cobra.NoArgs,
+		RunE:                  statusAction,
+		DisableFlagsInUseLine: true,
+	}
+	flags := cmd.Flags()
+	flags.Bool("json", false, "jsonify output")
+	return cmd
+}
+
+func statusAction(cmd *cobra.Command, args []string) error {
+	stdout := cmd.OutOrStdout()
+	flagJson, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+	st, err := instpool.Load()
+	if err != nil {
+		return err
+	}
+	if flagJson {
+		b, err := json.MarshalIndent(st, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdout, string(b))
+		return err
+	}
+	fmt.Fprintf(stdout, "Size: %d (available: %d, creating: %d, leased: %d, deleting: %d)\n", st.Size,
+		st.Count(instpool.MemberAvailable), st.Count(instpool.MemberCreating), st.Count(instpool.MemberLeased), st.Count(instpool.MemberDeleting))
+	if st.Template != "" {
+		fmt.Fprintf(stdout, "Template: %s\n", st.Template)
+	}
+	if len(st.Members) == 0 {
+		return nil
+	}
+	fmt.Fprintln(stdout)
+	w := tabwriter.NewWriter(stdout, 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tSTATE\tRECYCLED\tUPDATED")
+	for _, m := range st.Members {
+		if _, err = fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", m.Instance, m.State, m.Recycled, m.Updated.Format(time.DateTime)); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
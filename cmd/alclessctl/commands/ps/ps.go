@@ -0,0 +1,160 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/pkg/errdefs"
+	"github.com/AkihiroSuda/alcless/pkg/procutil"
+	"github.com/AkihiroSuda/alcless/pkg/store"
+	"github.com/AkihiroSuda/alcless/pkg/userutil"
+)
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "ps [INSTANCE]",
+		Short:                 "List the processes of instances",
+		Args:                  cobra.MaximumNArgs(1),
+		RunE:                  action,
+		DisableFlagsInUseLine: true,
+	}
+	flags := cmd.Flags()
+	flags.Bool("json", false, "jsonify output")
+	flags.BoolP("quiet", "q", false, "only show PIDs")
+	return cmd
+}
+
+// Entry is a process with the name of the instance.
+type Entry struct {
+	Instance string `json:"instance"`
+	procutil.Process
+}
+
+// Entries lists the processes of the instance.
+// If instName is empty, the processes of all the instances are listed.
+func Entries(ctx context.Context, instName string) ([]Entry, error) {
+	var insts []store.Instance
+	if instName == "" {
+		var err error
+		insts, err = store.Instances(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if err := store.ValidateName(instName); err != nil {
+			return nil, err
+		}
+		instUser := userutil.UserFromInstance(instName)
+		instUserExists, err := userutil.Exists(instUser)
+		if err != nil {
+			return nil, err
+		}
+		if !instUserExists {
+			return nil, &errdefs.InstanceNotFoundError{Instance: instName}
+		}
+		insts = []store.Instance{{Name: instName, User: instUser}}
+	}
+	var res []Entry
+	for _, inst := range insts {
+		procs, err := procutil.List(ctx, inst.User)
+		if err != nil {
+			return res, err
+		}
+		for _, p := range procs {
+			res = append(res, Entry{Instance: inst.Name, Process: p})
+		}
+	}
+	return res, nil
+}
+
+// WriteTable writes the entries as a table.
+func WriteTable(w io.Writer, entries []Entry) error {
+	tw := tabwriter.NewWriter(w, 4, 8, 4, ' ', 0)
+	fmt.Fprintln(tw, "INSTANCE\tPID\t%CPU\t%MEM\tRSS\tSTARTED\tCOMMAND")
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.1f\t%s\t%s\t%s\n",
+			e.Instance, e.PID, e.CPU, e.Memory, formatKiB(e.RSS), e.Started.Format(time.DateTime), e.Command); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func formatKiB(kib int64) string {
+	switch {
+	case kib >= 1024*1024:
+		return fmt.Sprintf("%.1fGiB", float64(kib)/(1024*1024))
+	case kib >= 1024:
+		return fmt.Sprintf("%.1fMiB", float64(kib)/1024)
+	default:
+		return fmt.Sprintf("%dKiB", kib)
+	}
+}
+
+func action(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	stdout := cmd.OutOrStdout()
+	flags := cmd.Flags()
+	flagJson, err := flags.GetBool("json")
+	if err != nil {
+		return err
+	}
+	flagQuiet, err := flags.GetBool("quiet")
+	if err != nil {
+		return err
+	}
+	if flagJson && flagQuiet {
+		return errors.New("option --json conflicts with option --quiet")
+	}
+	var instName string
+	if len(args) > 0 {
+		instName = args[0]
+	}
+	entries, err := Entries(ctx, instName)
+	if err != nil {
+		return err
+	}
+	switch {
+	case flagJson:
+		// single JSON object per line (similar to `alclessctl list --json`)
+		enc := json.NewEncoder(stdout)
+		enc.SetEscapeHTML(false)
+		for _, e := range entries {
+			if err = enc.Encode(e); err != nil {
+				return err
+			}
+		}
+	case flagQuiet:
+		for _, e := range entries {
+			if _, err = fmt.Fprintln(stdout, e.PID); err != nil {
+				return err
+			}
+		}
+	default:
+		return WriteTable(stdout, entries)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replay
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+
+	"al.essio.dev/pkg/shellescape"
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/pkg/asciicast"
+	"github.com/AkihiroSuda/alcless/pkg/recorder"
+)
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "replay FILE",
+		Short:                 "Replay a session recorded with `alclessctl shell --record`",
+		Args:                  cobra.ExactArgs(1),
+		RunE:                  action,
+		DisableFlagsInUseLine: true,
+	}
+	flags := cmd.Flags()
+	flags.Float64("speed", 1.0, "playback speed")
+	flags.Duration("idle-time-limit", 0, "limit the idle time between the events (0 for no limit)")
+	return cmd
+}
+
+func action(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	flags := cmd.Flags()
+	flagSpeed, err := flags.GetFloat64("speed")
+	if err != nil {
+		return err
+	}
+	if flagSpeed <= 0 {
+		return errors.New("speed must be positive")
+	}
+	flagIdleTimeLimit, err := flags.GetDuration("idle-time-limit")
+	if err != nil {
+		return err
+	}
+	castPath := args[0]
+	md, err := recorder.LoadMetadata(castPath)
+	switch {
+	case err == nil:
+		slog.InfoContext(ctx, "Replaying a session",
+			"instance", md.Instance,
+			"command", shellescape.QuoteCommand(md.Command),
+			"hostWD", md.HostWD,
+			"guestWD", md.GuestWD,
+			"started", md.Started)
+		defer slog.InfoContext(ctx, "End of the session",
+			"exitCode", md.ExitCode,
+			"syncBack", md.SyncBack,
+			"finished", md.Finished)
+	case errors.Is(err, os.ErrNotExist):
+		slog.DebugContext(ctx, "No metadata", "file", castPath+recorder.MetadataSuffix)
+	default:
+		slog.WarnContext(ctx, "Failed to load the metadata", "file", castPath+recorder.MetadataSuffix, "error", err)
+	}
+	f, err := os.Open(castPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := asciicast.NewReader(f)
+	if err != nil {
+		return err
+	}
+	return asciicast.Play(ctx, r, cmd.OutOrStdout(), asciicast.PlayOpts{
+		Speed:         flagSpeed,
+		IdleTimeLimit: flagIdleTimeLimit,
+	})
+}
@@ -0,0 +1,221 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package run
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/create"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/delete"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/pool"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/shell"
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/errdefs"
+	instpool "github.com/AkihiroSuda/alcless/pkg/pool"
+)
+
+const example = `
+  Try a tool once, in a throwaway instance:
+  $ cd ~/SOME_DIRECTORY
+  $ alclessctl run --rm brew install xz
+
+  Run a command in a pre-created instance of the pool:
+  $ alclessctl run --pool make test`
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "run [--rm] [--template TEMPLATE | --pool] COMMAND [ARGS]...",
+		Short:                 "Run a command in a new instance",
+		Example:               example,
+		RunE:                  action,
+		DisableFlagsInUseLine: true,
+	}
+	shell.AddFlags(cmd)
+	flags := cmd.Flags()
+	flags.Bool("rm", false, "delete the instance after the command exits")
+	flags.String("template", "template://default", "template of the instance")
+	flags.String("name", "", "name of the instance (default: tmp-RANDOM)")
+	flags.Bool("pool", false, "lease an instance from the pool instead of creating one, and wipe it after the command exits (implies --rm)")
+	for _, f := range unsupportedShellFlags {
+		if err := flags.MarkHidden(f); err != nil {
+			panic(err)
+		}
+	}
+	return cmd
+}
+
+// unsupportedShellFlags are the flags of `alclessctl shell` that cannot be used for a new instance.
+var unsupportedShellFlags = []string{"detach", "session", "instances", "all", "sync-back-from"}
+
+// nameRandomLen is the length of the random part of the instance name, in bytes.
+const nameRandomLen = 4
+
+func generateName() (string, error) {
+	b := make([]byte, nameRandomLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "tmp-" + hex.EncodeToString(b), nil
+}
+
+func action(cmd *cobra.Command, args []string) error {
+	if cmdutil.IsDryRun(cmd.Context()) {
+		return errors.New("`alclessctl run` does not support --dry-run (Hint: use `alclessctl create` and `alclessctl shell`)")
+	}
+	flags := cmd.Flags()
+	for _, f := range unsupportedShellFlags {
+		if flags.Changed(f) {
+			return fmt.Errorf("option --%s is not supported for `alclessctl run`", f)
+		}
+	}
+	flagRm, err := flags.GetBool("rm")
+	if err != nil {
+		return err
+	}
+	flagPool, err := flags.GetBool("pool")
+	if err != nil {
+		return err
+	}
+	if flagPool {
+		if flags.Changed("template") || flags.Changed("name") {
+			return errors.New("option --pool conflicts with option --template and --name")
+		}
+		return runPooled(cmd, args)
+	}
+	flagTemplate, err := flags.GetString("template")
+	if err != nil {
+		return err
+	}
+	if err = create.ValidateTemplate(flagTemplate); err != nil {
+		return err
+	}
+	instName, err := flags.GetString("name")
+	if err != nil {
+		return err
+	}
+	if instName == "" {
+		if instName, err = generateName(); err != nil {
+			return err
+		}
+	}
+	createOpts, err := create.OptionsFromCobra(cmd)
+	if err != nil {
+		return err
+	}
+	if !flagRm {
+		if err = create.Create(cmd, instName, createOpts); err != nil {
+			return err
+		}
+		slog.InfoContext(cmd.Context(), fmt.Sprintf("The instance %q is kept (Hint: run `alclessctl delete %s` to delete it)", instName, instName))
+		return shell.Action(cmd, append([]string{instName}, args...))
+	}
+	teardown := func(ctx context.Context, opts *cmdutil.RunOpts) error {
+		return delete.Delete(ctx, instName, false, opts)
+	}
+	setup := func(cmd *cobra.Command, instName string) error {
+		return create.Create(cmd, instName, createOpts)
+	}
+	return runEphemeral(cmd, instName, args, setup, teardown)
+}
+
+// runPooled leases an instance from the pool, runs the command, wipes the instance, and refills the pool.
+func runPooled(cmd *cobra.Command, args []string) error {
+	instName, err := instpool.Lease()
+	if err != nil {
+		if errors.Is(err, instpool.ErrNoAvailable) {
+			return fmt.Errorf("%w (Hint: run `alclessctl pool size N`)", err)
+		}
+		return err
+	}
+	slog.InfoContext(cmd.Context(), "Leased an instance from the pool", "instance", instName)
+	setup := func(*cobra.Command, string) error { return nil }
+	teardown := func(ctx context.Context, opts *cmdutil.RunOpts) error {
+		return pool.Wipe(ctx, instName, opts)
+	}
+	if err = runEphemeral(cmd, instName, args, setup, teardown); err != nil {
+		return err
+	}
+	return pool.Fill(cmd)
+}
+
+// runEphemeral sets up the instance, runs the command, and tears down the instance.
+// The instance is torn down even when the command fails, or when alclessctl receives SIGINT or SIGTERM.
+func runEphemeral(cmd *cobra.Command, instName string, args []string,
+	setup func(cmd *cobra.Command, instName string) error, teardown func(ctx context.Context, opts *cmdutil.RunOpts) error) error {
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+	// cleanupCtx is not cancelled by signals
+	cleanupCtx := context.WithoutCancel(ctx)
+
+	// A signal cancels the context, so that the command is killed and the instance is torn down below.
+	// The second signal terminates alclessctl immediately, without tearing down the instance.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	var (
+		sigMu       sync.Mutex
+		receivedSig os.Signal
+	)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			signal.Stop(sigCh)
+			slog.WarnContext(cleanupCtx, "Received a signal, cleaning up (Press Ctrl-C again to exit without cleaning up)", "signal", sig)
+			sigMu.Lock()
+			receivedSig = sig
+			sigMu.Unlock()
+			cancel()
+		case <-done:
+		}
+	}()
+
+	cmd.SetContext(ctx)
+	err := setup(cmd, instName)
+	if err == nil {
+		err = shell.Action(cmd, append([]string{instName}, args...))
+	}
+
+	slog.InfoContext(cleanupCtx, "Tearing down the ephemeral instance", "instance", instName)
+	// The user already agreed to delete the instance by specifying --rm.
+	// No confirmation is asked here, as the cleanup has to proceed after Ctrl-C.
+	opts := &cmdutil.RunOpts{Stdout: cmd.ErrOrStderr(), Stderr: cmd.ErrOrStderr()}
+	if teardownErr := teardown(cleanupCtx, opts); teardownErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to delete the ephemeral instance %q (Hint: run `alclessctl delete %s`): %w", instName, instName, teardownErr))
+	}
+
+	sigMu.Lock()
+	sig := receivedSig
+	sigMu.Unlock()
+	if sig != nil {
+		sigErr := &errdefs.CommandError{Err: fmt.Errorf("interrupted by signal %v", sig), Code: 128 + int(sig.(syscall.Signal))}
+		return errors.Join(sigErr, err)
+	}
+	return err
+}
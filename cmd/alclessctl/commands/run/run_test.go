@@ -0,0 +1,49 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package run
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"gotest.tools/v3/assert"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/errdefs"
+)
+
+func TestRunEphemeralSignal(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	setup := func(cmd *cobra.Command, _ string) error {
+		assert.NilError(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+		<-cmd.Context().Done()
+		return cmd.Context().Err()
+	}
+	var teardowns int
+	teardown := func(ctx context.Context, _ *cmdutil.RunOpts) error {
+		assert.NilError(t, ctx.Err())
+		teardowns++
+		return nil
+	}
+	err := runEphemeral(cmd, "foo", nil, setup, teardown)
+	assert.Equal(t, 1, teardowns)
+	assert.Equal(t, 128+int(syscall.SIGINT), errdefs.ExitCode(err))
+}
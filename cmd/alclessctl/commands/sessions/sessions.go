@@ -0,0 +1,116 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"al.essio.dev/pkg/shellescape"
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/pkg/session"
+)
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "sessions",
+		Short:                 "List detached sessions",
+		Args:                  cobra.NoArgs,
+		RunE:                  action,
+		DisableFlagsInUseLine: true,
+	}
+	flags := cmd.Flags()
+	flags.Bool("json", false, "jsonify output")
+	flags.BoolP("quiet", "q", false, "only show names")
+	return cmd
+}
+
+type entry struct {
+	session.Session
+	Status   session.Status `json:"status"`
+	ExitCode *int           `json:"exitCode,omitempty"`
+}
+
+func action(cmd *cobra.Command, args []string) error {
+	stdout := cmd.OutOrStdout()
+	flags := cmd.Flags()
+	flagJson, err := flags.GetBool("json")
+	if err != nil {
+		return err
+	}
+	flagQuiet, err := flags.GetBool("quiet")
+	if err != nil {
+		return err
+	}
+	if flagJson && flagQuiet {
+		return errors.New("option --json conflicts with option --quiet")
+	}
+	sessions, err := session.List()
+	if err != nil {
+		return err
+	}
+	var entries []entry
+	for _, s := range sessions {
+		st, exitCode, err := s.Status()
+		if err != nil {
+			return err
+		}
+		e := entry{Session: s, Status: st}
+		if st == session.StatusExited {
+			e.ExitCode = &exitCode
+		}
+		entries = append(entries, e)
+	}
+	switch {
+	case flagJson:
+		// single JSON object per line (similar to `alclessctl list --json`)
+		enc := json.NewEncoder(stdout)
+		enc.SetEscapeHTML(false)
+		for _, e := range entries {
+			if err = enc.Encode(e); err != nil {
+				return err
+			}
+		}
+	case flagQuiet:
+		for _, e := range entries {
+			if _, err = fmt.Fprintln(stdout, e.Name); err != nil {
+				return err
+			}
+		}
+	default:
+		w := tabwriter.NewWriter(stdout, 4, 8, 4, ' ', 0)
+		fmt.Fprintln(w, "NAME\tINSTANCE\tSTATUS\tCREATED\tCOMMAND")
+		for _, e := range entries {
+			status := string(e.Status)
+			if e.ExitCode != nil {
+				status = fmt.Sprintf("%s (%d)", status, *e.ExitCode)
+			}
+			if _, err = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				e.Name, e.Instance, status, e.Created.Format(time.DateTime), shellescape.QuoteCommand(e.Command)); err != nil {
+				return err
+			}
+		}
+		if err = w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package shell
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+)
+
+// newFreshDir returns a new path for a per-session directory.
+// The directory is not created by this function.
+func newFreshDir(instUserHome string) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	name := time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+	return filepath.Join(instUserHome, sudo.FreshDirParent, name), nil
+}
+
+// RemoveFreshDir removes the per-session directory created for `alclessctl shell --fresh`.
+func RemoveFreshDir(ctx context.Context, instUser, freshDir string, o ...sudo.Opt) error {
+	if filepath.Base(filepath.Dir(freshDir)) != sudo.FreshDirParent || strings.Contains(freshDir, "..") {
+		return fmt.Errorf("refusing to remove an unexpected directory %q", freshDir)
+	}
+	slog.InfoContext(ctx, "Removing the fresh directory", "dir", freshDir)
+	rmCmd := sudo.Cmd(ctx, instUser, "", "rm", []string{"-rf", freshDir}, o...)
+	return cmdutil.Run(ctx, []*exec.Cmd{rmCmd}, nil)
+}
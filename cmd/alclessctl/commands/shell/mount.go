@@ -0,0 +1,148 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/project"
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+	"github.com/AkihiroSuda/alcless/pkg/workdir"
+)
+
+// mountsFromCobra returns the mounts specified with --mount-ro, and in the config of the project of hostWD.
+// The mounts in the project config have to be confirmed, as the config is a part of the untrusted repository content.
+// Every mount is checked against the path policy.
+func mountsFromCobra(cmd *cobra.Command, hostWD string) ([]project.Mount, error) {
+	flagMountRO, err := cmd.Flags().GetStringArray("mount-ro")
+	if err != nil {
+		return nil, err
+	}
+	var res []project.Mount
+	root, err := project.FindRoot(hostWD)
+	if err != nil {
+		return nil, err
+	}
+	if root != "" {
+		projCfg, err := project.LoadConfig(root)
+		if err != nil {
+			return nil, err
+		}
+		projCfgPath := filepath.Join(root, project.MarkerFile)
+		var projMounts []project.Mount
+		for _, spec := range projCfg.MountRO {
+			m, err := project.ParseMount(spec, root)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", projCfgPath, err)
+			}
+			if err = checkProtectedPath(m.HostPath); err != nil {
+				return nil, fmt.Errorf("%s: %w", projCfgPath, err)
+			}
+			projMounts = append(projMounts, m)
+		}
+		if projMounts, err = confirmProjectMounts(cmd, projCfgPath, projMounts); err != nil {
+			return nil, err
+		}
+		res = append(res, projMounts...)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range flagMountRO {
+		m, err := project.ParseMount(spec, cwd)
+		if err != nil {
+			return nil, err
+		}
+		if err = checkProtectedPath(m.HostPath); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// confirmProjectMounts confirms the mounts in the project config with the confirmer.
+// The mounts are ignored when no confirmer is available (e.g., --tty=false without --confirmer).
+func confirmProjectMounts(cmd *cobra.Command, projCfgPath string, mounts []project.Mount) ([]project.Mount, error) {
+	ctx := cmd.Context()
+	if len(mounts) == 0 || cmdutil.IsDryRun(ctx) {
+		return mounts, nil
+	}
+	confirmer, err := cmdutil.ConfirmerFromCobra(cmd)
+	if err != nil {
+		return nil, err
+	}
+	plan := &cmdutil.Plan{Kind: cmdutil.PlanMountRO}
+	for _, m := range mounts {
+		plan.Paths = append(plan.Paths, m.HostPath)
+	}
+	if confirmer == nil {
+		slog.WarnContext(ctx, "Ignoring the read-only mounts in the project config, as they cannot be confirmed (Hint: specify --confirmer, or specify --mount-ro instead)",
+			"config", projCfgPath, "paths", plan.Paths)
+		return nil, nil
+	}
+	if err = confirmer.Confirm(ctx, plan); err != nil {
+		return nil, fmt.Errorf("the read-only mounts in %q were not confirmed: %w", projCfgPath, err)
+	}
+	return mounts, nil
+}
+
+// syncMounts syncs the mounts into the instance.
+// The mounts are never synced back, so they must not overlap with guestWD.
+func syncMounts(ctx context.Context, stdout, stderr io.Writer, instName, instUser, instUserHome string,
+	policy workdir.Policy, mounts []project.Mount, guestWD string, o ...sudo.Opt) error {
+	for _, m := range mounts {
+		guestPath := m.GuestPath
+		switch {
+		case guestPath == "":
+			rel, err := resolveWorkdir(ctx, instName, m.HostPath, policy)
+			if err != nil {
+				return err
+			}
+			guestPath = filepath.Join(instUserHome, rel)
+		case !filepath.IsAbs(guestPath):
+			guestPath = filepath.Join(instUserHome, guestPath)
+		}
+		if err := checkProtectedPath(m.HostPath); err != nil {
+			return err
+		}
+		if isWithin(guestPath, guestWD) || isWithin(guestWD, guestPath) {
+			return fmt.Errorf("the guest path %q of the read-only mount %q must not overlap with the guest working directory %q",
+				guestPath, m.HostPath, guestWD)
+		}
+		if _, err := syncIn(ctx, stdout, stderr, instName, instUser, m.HostPath, guestPath, o...); err != nil {
+			return fmt.Errorf("failed to sync the read-only mount %q: %w", m.HostPath, err)
+		}
+	}
+	return nil
+}
+
+// isWithin returns true if p is dir or a descendant of dir.
+func isWithin(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
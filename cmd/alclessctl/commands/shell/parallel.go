@@ -0,0 +1,324 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/errdefs"
+	"github.com/AkihiroSuda/alcless/pkg/prefixwriter"
+	"github.com/AkihiroSuda/alcless/pkg/project"
+	"github.com/AkihiroSuda/alcless/pkg/rsync"
+	"github.com/AkihiroSuda/alcless/pkg/store"
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+)
+
+// parallelResult is the result of running a command in one of the instances.
+type parallelResult struct {
+	Instance string
+	GuestWD  string
+	// Err is the error of the command, or the error of the preparation.
+	Err      error
+	Duration time.Duration
+	// Changes are the changes that would be applied by syncing back.
+	Changes rsync.Changes
+	// Planned is true when Changes is valid.
+	Planned bool
+}
+
+// parallelInstances returns the instance names specified with --instances or --all.
+// Nil is returned when neither is specified.
+func parallelInstances(cmd *cobra.Command) ([]string, error) {
+	ctx := cmd.Context()
+	flags := cmd.Flags()
+	flagInstances, err := flags.GetStringSlice("instances")
+	if err != nil {
+		return nil, err
+	}
+	flagAll, err := flags.GetBool("all")
+	if err != nil {
+		return nil, err
+	}
+	if !flagAll {
+		return flagInstances, nil
+	}
+	if len(flagInstances) > 0 {
+		return nil, errors.New("option --all conflicts with option --instances")
+	}
+	insts, err := store.Instances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(insts) == 0 {
+		return nil, errors.New("no instance exists (Hint: run `alclessctl create` first)")
+	}
+	res := make([]string, len(insts))
+	for i, inst := range insts {
+		res[i] = inst.Name
+	}
+	return res, nil
+}
+
+// parallelAction runs the command concurrently in the instances.
+// Each instance gets its own copy of the working directory.
+// The files can be synced back from one of the instances at most.
+func parallelAction(cmd *cobra.Command, instNames, args []string) error {
+	ctx := cmd.Context()
+	flags := cmd.Flags()
+	for _, flagName := range []string{"detach", "session", "record", "record-input", "result-json", "workdir", "shell", "fresh", "keep"} {
+		if flags.Changed(flagName) {
+			return fmt.Errorf("option --%s is not supported for multiple instances", flagName)
+		}
+	}
+	if len(args) == 0 {
+		return errors.New("a command has to be specified for multiple instances")
+	}
+	for i, instName := range instNames {
+		if err := store.ValidateName(instName); err != nil {
+			return err
+		}
+		if slices.Contains(instNames[:i], instName) {
+			return fmt.Errorf("instance %q is specified twice", instName)
+		}
+	}
+	flagPlain, err := flags.GetBool("plain")
+	if err != nil {
+		return err
+	}
+	flagReadOnly, err := flags.GetBool("read-only")
+	if err != nil {
+		return err
+	}
+	flagKillLeftovers, err := flags.GetBool("kill-leftovers")
+	if err != nil {
+		return err
+	}
+	flagSyncBackFrom, err := flags.GetString("sync-back-from")
+	if err != nil {
+		return err
+	}
+	if flagSyncBackFrom != "" && !slices.Contains(instNames, flagSyncBackFrom) {
+		return fmt.Errorf("instance %q specified with --sync-back-from is not one of the instances %v", flagSyncBackFrom, instNames)
+	}
+	hostWD, subdir, err := hostWorkdir(cmd)
+	if err != nil {
+		return err
+	}
+	var mounts []project.Mount
+	if !flagPlain {
+		if err = checkProtectedPath(hostWD); err != nil {
+			return err
+		}
+		if mounts, err = mountsFromCobra(cmd, hostWD); err != nil {
+			return err
+		}
+	}
+
+	var (
+		outMu   sync.Mutex
+		wg      sync.WaitGroup
+		results = make([]parallelResult, len(instNames))
+	)
+	for i, instName := range instNames {
+		wg.Go(func() {
+			stdout := prefixwriter.New(cmd.OutOrStdout(), &outMu, "["+instName+"] ")
+			stderr := prefixwriter.New(cmd.ErrOrStderr(), &outMu, "["+instName+"] ")
+			results[i] = runParallel(ctx, cmd, instName, hostWD, subdir, mounts, args, stdout, stderr, flagPlain, flagReadOnly, flagKillLeftovers)
+			for _, w := range []*prefixwriter.Writer{stdout, stderr} {
+				if err := w.Flush(); err != nil {
+					slog.WarnContext(ctx, "Failed to flush the output", "instance", instName, "error", err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	if err = writeParallelSummary(cmd.OutOrStdout(), results); err != nil {
+		return err
+	}
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("instance %q: %w", r.Instance, r.Err))
+		}
+	}
+	if !flagPlain && !flagReadOnly {
+		if err = parallelSyncBack(cmd, results, hostWD, flagSyncBackFrom); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func runParallel(ctx context.Context, cmd *cobra.Command, instName, hostWD, subdir string, mounts []project.Mount, args []string,
+	stdout, stderr io.Writer, plain, readOnly, killLeftovers bool) parallelResult {
+	res := parallelResult{Instance: instName}
+	started := time.Now()
+	defer func() {
+		res.Duration = time.Since(started)
+	}()
+	instUser, instUserHome, err := lookupInstance(ctx, instName)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	instCfg, err := store.LoadConfig(instName)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	limits, err := cmdutil.LimitsFromCobra(cmd, instCfg.Limits)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	cmdOpts, err := sudoOpts(instCfg, limits)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	policy, err := workdirPolicy(cmd, instCfg)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	rel, err := resolveWorkdir(ctx, instName, hostWD, policy)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.GuestWD = filepath.Join(instUserHome, rel)
+	if !plain {
+		if _, err = syncIn(ctx, stdout, stderr, instName, instUser, hostWD, res.GuestWD, instCfg.SudoOpts()...); err != nil {
+			res.Err = &errdefs.SyncInError{Err: err}
+			return res
+		}
+		if err = syncMounts(ctx, stdout, stderr, instName, instUser, instUserHome, policy, mounts, res.GuestWD, instCfg.SudoOpts()...); err != nil {
+			res.Err = &errdefs.SyncInError{Err: err}
+			return res
+		}
+	}
+	var sessionID string
+	if killLeftovers {
+		if sessionID, err = NewSessionID(); err != nil {
+			res.Err = err
+			return res
+		}
+		cmdOpts = append(cmdOpts, sudo.WithSession(sessionID))
+	}
+	sudoCmd := sudo.Cmd(ctx, instUser, filepath.Join(res.GuestWD, subdir), args[0], args[1:], cmdOpts...)
+	// The stdin is not propagated, as it cannot be shared across the instances
+	if err = cmdutil.Run(ctx, []*exec.Cmd{sudoCmd}, &cmdutil.RunOpts{Stdout: stdout, Stderr: stderr}); err != nil {
+		res.Err = errdefs.NewCommandError(err)
+	}
+	if killLeftovers {
+		// The changes are still planned on an error, as in `alclessctl shell`
+		if err = KillLeftovers(ctx, instUser, sessionID, instCfg.SudoOpts()...); err != nil {
+			slog.ErrorContext(ctx, err.Error(), "instance", instName)
+			res.Err = errors.Join(res.Err, err)
+		}
+	}
+	if !plain && !readOnly {
+		if res.Changes, err = planSyncBack(ctx, instName, res.GuestWD, hostWD, stderr); err != nil {
+			res.Err = errors.Join(res.Err, fmt.Errorf("failed to compute the changes to sync back: %w", err))
+			return res
+		}
+		res.Planned = true
+	}
+	return res
+}
+
+func writeParallelSummary(w io.Writer, results []parallelResult) error {
+	tw := tabwriter.NewWriter(w, 4, 8, 4, ' ', 0)
+	fmt.Fprintln(tw, "INSTANCE\tEXIT\tDURATION\tCREATED\tMODIFIED\tDELETED")
+	for _, r := range results {
+		exit := "0"
+		if r.Err != nil {
+			exit = fmt.Sprintf("%d", errdefs.ExitCode(r.Err))
+		}
+		created, modified, deleted := "-", "-", "-"
+		if r.Planned {
+			created = fmt.Sprintf("%d", len(r.Changes.Created))
+			modified = fmt.Sprintf("%d", len(r.Changes.Modified))
+			deleted = fmt.Sprintf("%d", len(r.Changes.Deleted))
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Instance, exit, r.Duration.Round(time.Millisecond), created, modified, deleted); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// parallelSyncBack syncs back the files from the instance specified with --sync-back-from,
+// or from the instance chosen by the user.
+func parallelSyncBack(cmd *cobra.Command, results []parallelResult, hostWD, from string) error {
+	ctx := cmd.Context()
+	var candidates []string
+	for _, r := range results {
+		if r.Planned && !r.Changes.Empty() {
+			candidates = append(candidates, r.Instance)
+		}
+	}
+	if from == "" {
+		if len(candidates) == 0 {
+			slog.InfoContext(ctx, "⬅️Nothing to sync back")
+			return nil
+		}
+		flagTty, err := cmd.Flags().GetBool("tty")
+		if err != nil {
+			return err
+		}
+		if !flagTty {
+			slog.InfoContext(ctx, "⬅️Not syncing back the files (Hint: specify --sync-back-from=INSTANCE)", "candidates", candidates)
+			return nil
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "❓ Choose the instance to sync back the files from %v, or press return to skip: ", candidates)
+		from, err = cmdutil.ReadAnswer()
+		if errors.Is(err, cmdutil.ErrNoTerminal) {
+			return err
+		}
+		if err != nil || from == "" {
+			slog.InfoContext(ctx, "⬅️Not syncing back the files")
+			return nil
+		}
+		if !slices.Contains(candidates, from) {
+			return fmt.Errorf("instance %q is not one of the candidates %v", from, candidates)
+		}
+	}
+	i := slices.IndexFunc(results, func(r parallelResult) bool { return r.Instance == from })
+	r := results[i]
+	if !r.Planned {
+		return fmt.Errorf("cannot sync back the files from instance %q: %w", from, r.Err)
+	}
+	// The error of the command is already reported by the caller
+	_, err := SyncBack(ctx, cmd, r.Instance, r.GuestWD, hostWD, nil)
+	return err
+}
@@ -17,26 +17,52 @@
 package shell
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
-	"strings"
+	"syscall"
+	"time"
 
+	"al.essio.dev/pkg/shellescape"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
 	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
-	"github.com/AkihiroSuda/alcless/pkg/rsync"
+	"github.com/AkihiroSuda/alcless/pkg/envutil"
+	"github.com/AkihiroSuda/alcless/pkg/errdefs"
+	"github.com/AkihiroSuda/alcless/pkg/pathpolicy"
+	"github.com/AkihiroSuda/alcless/pkg/project"
+	"github.com/AkihiroSuda/alcless/pkg/recorder"
+	"github.com/AkihiroSuda/alcless/pkg/report"
+	"github.com/AkihiroSuda/alcless/pkg/session"
 	"github.com/AkihiroSuda/alcless/pkg/store"
 	"github.com/AkihiroSuda/alcless/pkg/sudo"
 	"github.com/AkihiroSuda/alcless/pkg/userutil"
+	"github.com/AkihiroSuda/alcless/pkg/workdir"
 )
 
+const long = `Run a command in an instance.
+
+The exit code is the exit code of the command, unless another stage fails:
+  0:     success
+  1:     generic error
+  121:   the instance does not exist
+  122:   syncing the files into the instance failed
+  123:   syncing the files back was rejected by the user
+  124:   syncing the files back failed
+  125:   the command could not be executed
+  128+N: the command was killed by the signal N
+  other: the exit code of the command
+
+The exit codes 1 and 121-125 may also be the exit code of the command itself.
+Use --result-json to distinguish them: "exitCode" is the exit code of the command,
+and "alclessctlExitCode" is the exit code of alclessctl.`
+
 const example = `
   Run commands (long form):
   $ cd ~/SOME_DIRECTORY
@@ -46,37 +72,69 @@ const example = `
   Run commands (short form):
   $ cd ~/SOME_DIRECTORY
   $ alcless brew install xz
-  $ alcless xz SOME_FILE`
+  $ alcless xz SOME_FILE
+
+  Run a command in several instances in parallel:
+  $ alclessctl shell --instances=foo,bar make test
+  $ alclessctl shell --all --sync-back-from=foo make`
 
 func New() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:                   "shell INSTANCE COMMAND [ARGS]...",
+		Use:                   "shell [--instances=INSTANCE,... | --all] INSTANCE COMMAND [ARGS]...",
 		Short:                 "Run a command in an instance",
+		Long:                  long,
 		Example:               example,
-		Args:                  cobra.MinimumNArgs(1),
-		RunE:                  action,
+		RunE:                  Action,
 		DisableFlagsInUseLine: true,
 	}
+	AddFlags(cmd)
+	return cmd
+}
 
+// AddFlags adds the flags of the shell command to cmd.
+// Commands that call [Action] must have these flags.
+func AddFlags(cmd *cobra.Command) {
 	flags := cmd.Flags()
 	flags.SetInterspersed(false)
 	flags.String("workdir", "", "specify working directory")
+	flags.Bool("project-root", envutil.Bool("ALCLESS_PROJECT_ROOT", false),
+		"sync the project root (the nearest directory with .alcless.yaml or .git) instead of the current directory, and run the command in the corresponding subdirectory [$ALCLESS_PROJECT_ROOT]")
+	flags.StringArray("mount-ro", nil, "sync HOSTPATH[:GUESTPATH] into the instance, without syncing it back (can be specified multiple times; also configurable as `mountRO` in .alcless.yaml)")
+	flags.String("workdir-mapping", os.Getenv("ALCLESS_WORKDIR_MAPPING"),
+		"mapping of the host working directory to the guest working directory: \"mirror\" (~/HOST_PATH), \"work\" (~/work/BASENAME), or a template like \"src/{{.Name}}\" (default: the instance config, or \"mirror\") [$ALCLESS_WORKDIR_MAPPING]")
 	flags.String("shell", "", "Shell interpreter, e.g. /bin/bash")
 	flags.Bool("read-only", false, "disable syncing back modified files")
-
-	return cmd
+	flags.Bool("fresh", false, "sync the files into a new empty directory, instead of reusing the directory of the previous sessions")
+	flags.Bool("keep", false, "keep the directory created for --fresh")
+	cmdutil.AddLimitsFlags(cmd)
+	flags.BoolP("detach", "d", false, "run the command in the background as a session (See `alclessctl sessions`)")
+	flags.String("session", "", "name of the detached session (default: INSTANCE-YYYYMMDD-hhmmss)")
+	flags.String("record", "", "record the terminal I/O to the file in the asciicast v2 format (See `alclessctl replay`)")
+	flags.Bool("record-input", false, "record the input too (may contain secrets)")
+	flags.String("result-json", "", "write the result of the session to the file in JSON")
+	flags.StringSlice("instances", nil, "run the command in the instances in parallel (INSTANCE is omitted from the arguments)")
+	flags.Bool("all", false, "run the command in all the instances in parallel (INSTANCE is omitted from the arguments)")
+	flags.String("sync-back-from", "", "instance to sync back the files from, when running in multiple instances")
+	flags.Bool("kill-leftovers", envutil.Bool("ALCLESS_KILL_LEFTOVERS", false), "terminate the processes started in the session when the command exits [$ALCLESS_KILL_LEFTOVERS]")
 }
 
-// Depth of "/Users/USER" is 3.
-const rsyncMinimumSrcDirDepth = 4
-
-func action(cmd *cobra.Command, args []string) error {
+// Action runs the command (args[1:]) in the instance (args[0]).
+func Action(cmd *cobra.Command, args []string) (retErr error) {
 	ctx := cmd.Context()
 	flags := cmd.Flags()
-	flagTty, err := flags.GetBool("tty")
+	instNames, err := parallelInstances(cmd)
 	if err != nil {
 		return err
 	}
+	if len(instNames) > 0 {
+		return parallelAction(cmd, instNames, args)
+	}
+	if len(args) == 0 {
+		return errors.New("requires INSTANCE")
+	}
+	if flags.Changed("sync-back-from") {
+		return errors.New("option --sync-back-from requires --instances or --all")
+	}
 	flagPlain, err := flags.GetBool("plain")
 	if err != nil {
 		return err
@@ -85,20 +143,99 @@ func action(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	flagKillLeftovers, err := flags.GetBool("kill-leftovers")
+	if err != nil {
+		return err
+	}
+	flagDetach, err := flags.GetBool("detach")
+	if err != nil {
+		return err
+	}
+	flagSession, err := flags.GetString("session")
+	if err != nil {
+		return err
+	}
+	if flagSession != "" {
+		if !flagDetach {
+			return errors.New("option --session requires --detach")
+		}
+		if _, err = session.Dir(flagSession); err != nil {
+			return fmt.Errorf("invalid session name %q: %w", flagSession, err)
+		}
+	}
+	flagRecord, err := flags.GetString("record")
+	if err != nil {
+		return err
+	}
+	flagRecordInput, err := flags.GetBool("record-input")
+	if err != nil {
+		return err
+	}
+	if flagDetach && (flagRecord != "" || flagRecordInput) {
+		return errors.New("option --detach conflicts with option --record and --record-input")
+	}
+	flagResultJSON, err := flags.GetString("result-json")
+	if err != nil {
+		return err
+	}
+	flagFresh, err := flags.GetBool("fresh")
+	if err != nil {
+		return err
+	}
+	flagKeep, err := flags.GetBool("keep")
+	if err != nil {
+		return err
+	}
+	if flagKeep && !flagFresh {
+		return errors.New("option --keep requires --fresh")
+	}
+	if flagFresh && flagPlain {
+		return errors.New("option --fresh conflicts with option --plain")
+	}
 	instName := args[0]
-	if err = store.ValidateName(instName); err != nil {
+	res := &report.Result{Instance: instName}
+	if flagResultJSON != "" {
+		if flagDetach {
+			return errors.New("option --detach conflicts with option --result-json")
+		}
+		collector := report.NewWarningCollector(slog.Default().Handler())
+		origLogger := slog.Default()
+		slog.SetDefault(slog.New(collector))
+		defer func() {
+			slog.SetDefault(origLogger)
+			res.Warnings = collector.Warnings()
+			if retErr != nil {
+				res.Error = retErr.Error()
+			}
+			res.AlclessctlExitCode = errdefs.ExitCode(retErr)
+			if err := res.Write(flagResultJSON); err != nil {
+				retErr = errors.Join(retErr, fmt.Errorf("failed to write the result to %q: %w", flagResultJSON, err))
+			}
+		}()
+	}
+	instUser, instUserHome, err := lookupInstance(ctx, instName)
+	if err != nil {
 		return err
 	}
-	instUser := userutil.UserFromInstance(instName)
-	instUserInfo, err := user.Lookup(instUser)
+
+	instCfg, err := store.LoadConfig(instName)
 	if err != nil {
-		var uee user.UnknownUserError
-		if errors.As(err, &uee) {
-			// TODO: run the `alclessctl create` command automatically
-			slog.DebugContext(ctx, "user does not exist", "user", instUser, "error", err)
-			return fmt.Errorf("instance %q does not exist (Hint: run `alclessctl create %s` first)", instName, instName)
+		return err
+	}
+	limits, err := cmdutil.LimitsFromCobra(cmd, instCfg.Limits)
+	if err != nil {
+		return err
+	}
+	cmdOpts, err := sudoOpts(instCfg, limits)
+	if err != nil {
+		return err
+	}
+	var sessionID string
+	if flagKillLeftovers {
+		if sessionID, err = NewSessionID(); err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to get user %q: %w", instUser, err)
+		cmdOpts = append(cmdOpts, sudo.WithSession(sessionID))
 	}
 
 	flagShell, err := flags.GetString("shell")
@@ -131,110 +268,369 @@ func action(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	instUserHome := instUserInfo.HomeDir
-	if instUserHome == "" {
-		return fmt.Errorf("failed to detect the home directory of the user %q", instUser)
-	}
-
-	hostWD, err := os.Getwd()
+	flagWorkdir, err := flags.GetString("workdir")
 	if err != nil {
 		return err
 	}
-	guestWD := filepath.Join(instUserHome, hostWD)
-	flagWorkdir, err := flags.GetString("workdir")
+	hostWD, subdir, err := hostWorkdir(cmd)
 	if err != nil {
 		return err
 	}
-	if flagWorkdir != "" {
-		guestWD = flagWorkdir
+	if subdir != "" && flagWorkdir != "" {
+		return errors.New("option --project-root conflicts with option --workdir")
 	}
-
+	var mounts []project.Mount
 	if !flagPlain {
-		const hint = "cd to a deeper directory, or run `alclessctl shell` with `--plain`"
-		hostHome, err := os.UserHomeDir()
+		if mounts, err = mountsFromCobra(cmd, hostWD); err != nil {
+			return err
+		}
+	} else if flags.Changed("mount-ro") {
+		return errors.New("option --mount-ro conflicts with option --plain")
+	}
+	policy, err := workdirPolicy(cmd, instCfg)
+	if err != nil {
+		return err
+	}
+	var guestWD, freshDir string
+	switch {
+	case flagWorkdir != "":
+		if flagFresh {
+			return errors.New("option --fresh conflicts with option --workdir")
+		}
+		guestWD = flagWorkdir
+	case flagFresh:
+		if freshDir, err = newFreshDir(instUserHome); err != nil {
+			return err
+		}
+		// The recorded mapping is honored, but a new mapping is not recorded for the transient directory
+		rel, err := workdir.Peek(instName, hostWD, policy)
 		if err != nil {
 			return err
 		}
-		if hostWD == hostHome {
-			return fmt.Errorf("the host working directory must not be $HOME, as this directory is being rsynced to the instance (Hint: %s)", hint)
-		} else {
-			srcWdDepth := len(strings.Split(hostWD, string(os.PathSeparator)))
-			// Depth of "/Users/USER" is 3
-			slog.DebugContext(ctx, "Working directory depth", "wd", hostWD, "depth", srcWdDepth)
-			if srcWdDepth < rsyncMinimumSrcDirDepth {
-				return fmt.Errorf("expected the depth of the host working directory (%q) to be more than %d, only got %d (Hint: %s)",
-					hostWD, rsyncMinimumSrcDirDepth, srcWdDepth, hint)
-			}
+		guestWD = filepath.Join(freshDir, rel)
+	case flagPlain:
+		// Not recorded, as the files are not synced in the plain mode
+		rel, err := workdir.Peek(instName, hostWD, policy)
+		if err != nil {
+			return err
 		}
-		rsyncSrc := hostWD + string(os.PathSeparator)
-		rsyncDst := instName + ":" + guestWD
-		slog.InfoContext(ctx, "➡️Syncing the files", "src", rsyncSrc, "dst", rsyncDst)
-		rsyncCmd, err := rsync.Cmd(ctx, instName, rsyncSrc, rsyncDst)
+		guestWD = filepath.Join(instUserHome, rel)
+	default:
+		// The mapping is recorded so that the later sessions find the same copy
+		rel, err := resolveWorkdir(ctx, instName, hostWD, policy)
 		if err != nil {
 			return err
 		}
-		rsyncCmds := []*exec.Cmd{
-			sudo.Cmd(ctx, instUser, "", "mkdir", []string{"-p", "-m", "700", guestWD}),
-			rsyncCmd,
+		guestWD = filepath.Join(instUserHome, rel)
+	}
+	if flagFresh {
+		if flagKeep {
+			slog.InfoContext(ctx, "The fresh directory will be kept", "dir", freshDir)
+		} else if !flagDetach {
+			// Removed after syncing back. Detached sessions remove it on `alclessctl finish`.
+			defer func() {
+				if err := RemoveFreshDir(context.WithoutCancel(ctx), instUser, freshDir, instCfg.SudoOpts()...); err != nil {
+					slog.WarnContext(ctx, "Failed to remove the fresh directory", "dir", freshDir, "error", err)
+				}
+			}()
+		}
+	}
+	res.HostWD = hostWD
+	res.GuestWD = guestWD
+	res.Command = append([]string{cmdExe}, cmdArgs...)
+	res.SyncBack.Decision = string(SyncBackSkipped)
+
+	if !flagPlain {
+		if err = checkProtectedPath(hostWD); err != nil {
+			return err
 		}
-		rsyncCmdOpts, err := cmdutil.RunOptsFromCobraNoStdin(cmd)
+		syncInStarted := time.Now()
+		res.SyncIn.Changes, err = syncIn(ctx, cmd.OutOrStdout(), cmd.ErrOrStderr(), instName, instUser, hostWD, guestWD, instCfg.SudoOpts()...)
 		if err != nil {
+			return &errdefs.SyncInError{Err: err}
+		}
+		if err = syncMounts(ctx, cmd.OutOrStdout(), cmd.ErrOrStderr(), instName, instUser, instUserHome, policy, mounts, guestWD, instCfg.SudoOpts()...); err != nil {
+			return &errdefs.SyncInError{Err: err}
+		}
+		res.Durations.SyncIn = time.Since(syncInStarted).Seconds()
+	}
+
+	sudoCmd := sudo.Cmd(ctx, instUser, filepath.Join(guestWD, subdir), cmdExe, cmdArgs, cmdOpts...)
+	dryRun := cmdutil.IsDryRun(ctx)
+	if flagDetach && !dryRun {
+		sess := &session.Session{
+			Name:     flagSession,
+			Instance: instName,
+			User:     instUser,
+			Command:  append([]string{cmdExe}, cmdArgs...),
+			HostWD:   hostWD,
+			GuestWD:  guestWD,
+			Plain:    flagPlain,
+			ReadOnly: flagReadOnly,
+		}
+		if sess.Name == "" {
+			sess.Name = session.DefaultName(instName)
+		}
+		if flagKillLeftovers {
+			sess.KillLeftovers = true
+			sess.SessionID = sessionID
+		}
+		if !flagKeep {
+			sess.FreshDir = freshDir
+		}
+		if err = session.Start(ctx, sess, sudoCmd); err != nil {
 			return err
 		}
-		if err = cmdutil.Run(ctx, rsyncCmds, rsyncCmdOpts); err != nil {
-			return fmt.Errorf("%w (Hint: run with `alclessctl shell --plain` as a workaround)", err)
+		slog.InfoContext(ctx, "Started a detached session", "session", sess.Name, "pid", sess.PID)
+		slog.InfoContext(ctx, fmt.Sprintf("Run `alclessctl logs -f %s` to see the output, and `alclessctl finish %s` to sync back the files after the session exits", sess.Name, sess.Name))
+		return nil
+	}
+	recordPath := flagRecord
+	if dryRun {
+		recordPath = ""
+	} else if recordPath == "" && instCfg.Record && isInteractive(cmd) {
+		// The non-interactive sessions, such as the rsync transport (`alclessctl shell --plain` via `rsync -e`),
+		// are not recorded by default, as the binary protocol is not a terminal I/O.
+		recordPath, err = store.NewRecordingPath(instName)
+		if err != nil {
+			return err
+		}
+	}
+	var recordMetadata *recorder.Metadata
+	if recordPath != "" {
+		recordMetadata = &recorder.Metadata{
+			Instance: instName,
+			Command:  append([]string{cmdExe}, cmdArgs...),
+			HostWD:   hostWD,
+			GuestWD:  guestWD,
+			Started:  time.Now(),
 		}
 	}
 
-	sudoCmd := sudo.Cmd(ctx, instUser, guestWD, cmdExe, cmdArgs)
-	sudoCmdOpts, err := cmdutil.RunOptsFromCobra(cmd) // Propagate stdin
-	if err != nil {
-		return err
+	var sudoCmdErr error
+	runStarted := time.Now()
+	if recordPath != "" {
+		slog.InfoContext(ctx, "Recording the session", "file", recordPath)
+		sudoCmdErr = runRecorded(ctx, cmd, sudoCmd, recordPath, flagRecordInput)
+		recordMetadata.Finished = time.Now()
+		recordMetadata.ExitCode = exitCode(sudoCmdErr)
+	} else {
+		sudoCmdOpts, err := cmdutil.RunOptsFromCobra(cmd) // Propagate stdin
+		if err != nil {
+			return err
+		}
+		sudoCmdOpts.Confirmer = nil // Not a privileged operation
+		sudoCmdErr = cmdutil.Run(ctx, []*exec.Cmd{sudoCmd}, sudoCmdOpts)
 	}
-	sudoCmdOpts.Confirm = false // Not a privileged operation
-	sudoCmdErr := cmdutil.Run(ctx, []*exec.Cmd{sudoCmd}, sudoCmdOpts)
+	res.Durations.Run = time.Since(runStarted).Seconds()
+	setExitStatus(res, sudoCmdErr)
 	if sudoCmdErr != nil {
 		slog.ErrorContext(ctx, sudoCmdErr.Error())
+		sudoCmdErr = errdefs.NewCommandError(sudoCmdErr)
 	}
 
-	if !flagPlain && !flagReadOnly {
-		rsyncSrc := instName + ":" + guestWD + string(os.PathSeparator)
-		rsyncDst := hostWD
-		var dryRunResultWasEmpty bool
-		if flagTty {
-			slog.InfoContext(ctx, "⬅️Syncing the files back (dry run)", "src", rsyncSrc, "dst", rsyncDst)
-			rsyncCmd, err := rsync.Cmd(ctx, instName, rsyncSrc, rsyncDst, rsync.WithDryRun())
-			if err != nil {
-				return err
-			}
-			// dry run does not need confirmation input
-			rsyncCmdOpts, err := cmdutil.RunOptsFromCobraNoStdin(cmd)
-			if err != nil {
-				return err
-			}
-			var dryRunStdout bytes.Buffer
-			rsyncCmdOpts.Stdout = io.MultiWriter(rsyncCmdOpts.Stdout, &dryRunStdout)
-			if err = cmdutil.Run(ctx, []*exec.Cmd{rsyncCmd}, rsyncCmdOpts); err != nil {
-				return err
-			}
-			dryRunResultWasEmpty = strings.TrimSpace(dryRunStdout.String()) == ""
-			// Confirmation prompt will be shown for the non-dry run
-			// TODO: print a warning if rsyncSrc is newer than rsyncDst
+	var killErr error
+	if flagKillLeftovers {
+		// Leftover processes are killed before syncing back the files, so that they cannot modify the files during the sync.
+		// The files are still synced back on an error, so that the result of the command is not lost.
+		if killErr = KillLeftovers(ctx, instUser, sessionID, instCfg.SudoOpts()...); killErr != nil {
+			slog.ErrorContext(ctx, killErr.Error())
 		}
-		if dryRunResultWasEmpty {
-			slog.InfoContext(ctx, "⬅️Nothing to sync back", "src", rsyncSrc, "dst", rsyncDst)
-		} else {
-			slog.InfoContext(ctx, "⬅️Syncing the files back", "src", rsyncSrc, "dst", rsyncDst)
-			rsyncCmd, err := rsync.Cmd(ctx, instName, rsyncSrc, rsyncDst)
-			if err != nil {
-				return err
-			}
-			if err = cmdutil.RunWithCobra(ctx, []*exec.Cmd{rsyncCmd}, cmd); err != nil {
-				return err
-			}
+	}
+
+	if !flagPlain && !flagReadOnly {
+		syncBackStarted := time.Now()
+		var syncBackRes *SyncBackResult
+		syncBackRes, err = SyncBack(ctx, cmd, instName, guestWD, hostWD, sudoCmdErr)
+		res.Durations.SyncBack = time.Since(syncBackStarted).Seconds()
+		res.SyncBack.Decision = string(syncBackRes.Decision)
+		res.SyncBack.Changes = syncBackRes.Changes
+	}
+	if recordMetadata != nil {
+		recordMetadata.SyncBack = res.SyncBack.Decision
+		if err := recorder.SaveMetadata(recordPath, recordMetadata); err != nil {
+			slog.WarnContext(ctx, "Failed to save the metadata of the recording", "file", recordPath, "error", err)
 		}
-		// TODO: create Homebrew wrappers (~alcless_USER_default/brew/bin/foo -> ~/.alcless/default/bin/foo)
+	}
+	if err != nil {
+		return errors.Join(err, killErr)
+	}
+	if killErr != nil {
+		// The exit code of the command takes precedence over the exit code of killErr
+		return errors.Join(sudoCmdErr, killErr)
 	}
 
 	return sudoCmdErr
 }
+
+// hostWorkdir returns the host working directory to be synced, and the subdirectory to run the command in.
+// The subdirectory is empty unless --project-root is specified.
+func hostWorkdir(cmd *cobra.Command) (string, string, error) {
+	ctx := cmd.Context()
+	flags := cmd.Flags()
+	hostWD, err := os.Getwd()
+	if err != nil {
+		return "", "", err
+	}
+	flagProjectRoot, err := flags.GetBool("project-root")
+	if err != nil {
+		return "", "", err
+	}
+	flagPlain, err := flags.GetBool("plain")
+	if err != nil {
+		return "", "", err
+	}
+	if !flagProjectRoot || flagPlain {
+		return hostWD, "", nil
+	}
+	root, err := project.FindRoot(hostWD)
+	if err != nil {
+		return "", "", err
+	}
+	if root == "" {
+		slog.WarnContext(ctx, "No project root was found, syncing the current directory", "dir", hostWD, "marker", project.MarkerFile)
+		return hostWD, "", nil
+	}
+	subdir, err := filepath.Rel(root, hostWD)
+	if err != nil {
+		return "", "", err
+	}
+	if subdir == "." {
+		subdir = ""
+	}
+	slog.DebugContext(ctx, "Detected the project root", "root", root, "subdir", subdir)
+	return root, subdir, nil
+}
+
+// resolveWorkdir resolves the guest working directory relative to the home of the instance user.
+// The mapping is not recorded in the dry-run mode.
+func resolveWorkdir(ctx context.Context, instName, hostWD string, policy workdir.Policy) (string, error) {
+	if cmdutil.IsDryRun(ctx) {
+		return workdir.Peek(instName, hostWD, policy)
+	}
+	return workdir.Resolve(instName, hostWD, policy)
+}
+
+// workdirPolicy returns the workdir mapping policy specified with --workdir-mapping, or in the instance config.
+func workdirPolicy(cmd *cobra.Command, instCfg *store.Config) (workdir.Policy, error) {
+	flagWorkdirMapping, err := cmd.Flags().GetString("workdir-mapping")
+	if err != nil {
+		return "", err
+	}
+	policy := workdir.Policy(flagWorkdirMapping)
+	if policy == "" {
+		policy = instCfg.WorkdirMapping
+	}
+	if policy == "" {
+		policy = workdir.DefaultPolicy
+	}
+	return policy, policy.Validate()
+}
+
+// sudoOpts returns the options of [sudo.Cmd] for running the command of the session.
+func sudoOpts(instCfg *store.Config, limits *sudo.Limits) ([]sudo.Opt, error) {
+	if instCfg.Sudoers.Restricted() && !limits.IsZero() {
+		return nil, errors.New("the limits cannot be applied to an instance in the restricted sudoers mode")
+	}
+	return append(instCfg.SudoOpts(), sudo.WithLimits(limits)), nil
+}
+
+// lookupInstance returns the user and the home directory of the instance.
+func lookupInstance(ctx context.Context, instName string) (string, string, error) {
+	if err := store.ValidateName(instName); err != nil {
+		return "", "", err
+	}
+	instUser := userutil.UserFromInstance(instName)
+	instUserInfo, err := userutil.Lookup(ctx, instUser)
+	if err != nil {
+		var uee user.UnknownUserError
+		if errors.As(err, &uee) {
+			// TODO: run the `alclessctl create` command automatically
+			slog.DebugContext(ctx, "user does not exist", "user", instUser, "error", err)
+			return "", "", &errdefs.InstanceNotFoundError{Instance: instName}
+		}
+		return "", "", fmt.Errorf("failed to get user %q: %w", instUser, err)
+	}
+	if instUserInfo.HomeDir == "" {
+		return "", "", fmt.Errorf("failed to detect the home directory of the user %q", instUser)
+	}
+	return instUser, instUserInfo.HomeDir, nil
+}
+
+// checkProtectedPath checks that the host path is not protected by the path policy (See [pathpolicy]).
+func checkProtectedPath(path string) error {
+	policy, err := pathpolicy.Load()
+	if err != nil {
+		return err
+	}
+	if err = policy.Check(path); err != nil {
+		configPath, _ := pathpolicy.ConfigPath()
+		return fmt.Errorf("refusing to sync: %w (Hint: cd to another directory, add the directory to `allow` in %s, or run `alclessctl shell` with `--plain`)", err, configPath)
+	}
+	return nil
+}
+
+func runRecorded(ctx context.Context, cmd *cobra.Command, sudoCmd *exec.Cmd, recordPath string, recordInput bool) error {
+	opts := recorder.Opts{RecordInput: recordInput}
+	var ok bool
+	if opts.Stdin, ok = cmd.InOrStdin().(*os.File); !ok {
+		return errors.New("recording requires the stdin to be a file")
+	}
+	if opts.Stdout, ok = cmd.OutOrStdout().(*os.File); !ok {
+		return errors.New("recording requires the stdout to be a file")
+	}
+	if opts.Stderr, ok = cmd.ErrOrStderr().(*os.File); !ok {
+		return errors.New("recording requires the stderr to be a file")
+	}
+	if err := os.MkdirAll(filepath.Dir(recordPath), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(recordPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	slog.DebugContext(ctx, "Running command", "cmd", sudoCmd.Args)
+	if err = recorder.Run(ctx, sudoCmd, f, opts); err != nil {
+		return fmt.Errorf("failed to run: %v: %w", shellescape.QuoteCommand(sudoCmd.Args), err)
+	}
+	return nil
+}
+
+// isInteractive returns true if the stdin is a terminal.
+func isInteractive(cmd *cobra.Command) bool {
+	f, ok := cmd.InOrStdin().(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// exitCode returns the exit code of the command that returned err.
+// -1 is returned if the exit code is unknown.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// setExitStatus sets the exit code or the signal of the command that returned err.
+func setExitStatus(res *report.Result, err error) {
+	if err == nil {
+		exitCode := 0
+		res.ExitCode = &exitCode
+		return
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		res.Signal = ws.Signal().String()
+		return
+	}
+	exitCode := exitErr.ExitCode()
+	res.ExitCode = &exitCode
+}
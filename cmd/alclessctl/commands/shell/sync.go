@@ -0,0 +1,240 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package shell
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/errdefs"
+	"github.com/AkihiroSuda/alcless/pkg/procutil"
+	"github.com/AkihiroSuda/alcless/pkg/rsync"
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+)
+
+func syncIn(ctx context.Context, stdout, stderr io.Writer, instName, instUser, hostWD, guestWD string, o ...sudo.Opt) (rsync.Changes, error) {
+	rsyncSrc := hostWD + string(os.PathSeparator)
+	rsyncDst := instName + ":" + guestWD
+	slog.InfoContext(ctx, "➡️Syncing the files", "src", rsyncSrc, "dst", rsyncDst)
+	rsyncCmd, err := rsync.Cmd(ctx, instName, rsyncSrc, rsyncDst)
+	if err != nil {
+		return rsync.Changes{}, err
+	}
+	rsyncCmds := []*exec.Cmd{
+		sudo.Cmd(ctx, instUser, "", "mkdir", []string{"-p", "-m", "700", guestWD}, o...),
+		rsyncCmd,
+	}
+	var rsyncStdout bytes.Buffer
+	rsyncCmdOpts := &cmdutil.RunOpts{
+		Stdout: io.MultiWriter(stdout, &rsyncStdout),
+		Stderr: stderr,
+	}
+	if err = cmdutil.Run(ctx, rsyncCmds, rsyncCmdOpts); err != nil {
+		return rsync.Changes{}, fmt.Errorf("%w (Hint: run with `alclessctl shell --plain` as a workaround)", err)
+	}
+	return rsync.ParseItemized(rsyncStdout.Bytes()), nil
+}
+
+// SyncBackDecision is the result of [SyncBack].
+type SyncBackDecision string
+
+const (
+	// SyncBackSkipped means that syncing back was not attempted (e.g., --read-only).
+	SyncBackSkipped = SyncBackDecision("skipped")
+	// SyncBackNothing means that there was nothing to sync back.
+	SyncBackNothing = SyncBackDecision("nothing")
+	SyncBackApplied = SyncBackDecision("applied")
+	// SyncBackRejected means that the user did not confirm syncing back.
+	SyncBackRejected = SyncBackDecision("rejected")
+	SyncBackFailed   = SyncBackDecision("failed")
+)
+
+// SyncBackResult is the result of [SyncBack].
+type SyncBackResult struct {
+	Decision SyncBackDecision
+	// Changes are the applied changes, or the planned changes if the decision is not [SyncBackApplied].
+	Changes rsync.Changes
+}
+
+// SyncBack syncs the guest working directory back to the host working directory.
+// When a confirmer is enabled (See [cmdutil.ConfirmerFromCobra]), the changes are computed with a dry run,
+// and confirmed before being applied.
+//
+// The returned error is [*errdefs.SyncBackRejectedError] or [*errdefs.SyncBackError],
+// with the CommandErr field set to cmdErr.
+func SyncBack(ctx context.Context, cmd *cobra.Command, instName, guestWD, hostWD string, cmdErr error) (*SyncBackResult, error) {
+	res, err := syncBack(ctx, cmd, instName, guestWD, hostWD)
+	if err != nil {
+		if errors.Is(err, cmdutil.ErrAborted) {
+			res.Decision = SyncBackRejected
+			return res, &errdefs.SyncBackRejectedError{Err: err, CommandErr: cmdErr}
+		}
+		res.Decision = SyncBackFailed
+		return res, &errdefs.SyncBackError{Err: err, CommandErr: cmdErr}
+	}
+	return res, nil
+}
+
+func syncBack(ctx context.Context, cmd *cobra.Command, instName, guestWD, hostWD string) (*SyncBackResult, error) {
+	res := &SyncBackResult{Decision: SyncBackFailed}
+	confirmer, err := cmdutil.ConfirmerFromCobra(cmd)
+	if err != nil {
+		return res, err
+	}
+	// The policy may have been changed since syncing in (e.g., detached sessions)
+	if err = checkProtectedPath(hostWD); err != nil {
+		return res, err
+	}
+	rsyncSrc := instName + ":" + guestWD + string(os.PathSeparator)
+	rsyncDst := hostWD
+	rsyncCmd, err := rsync.Cmd(ctx, instName, rsyncSrc, rsyncDst)
+	if err != nil {
+		return res, err
+	}
+	dryRun := cmdutil.IsDryRun(ctx)
+	if confirmer != nil && !dryRun {
+		slog.InfoContext(ctx, "⬅️Syncing the files back (dry run)", "src", rsyncSrc, "dst", rsyncDst)
+		dryRunCmd, err := rsync.Cmd(ctx, instName, rsyncSrc, rsyncDst, rsync.WithDryRun())
+		if err != nil {
+			return res, err
+		}
+		// dry run does not need confirmation input
+		dryRunCmdOpts, err := cmdutil.RunOptsFromCobraNoStdin(cmd)
+		if err != nil {
+			return res, err
+		}
+		var dryRunStdout bytes.Buffer
+		dryRunCmdOpts.Stdout = io.MultiWriter(dryRunCmdOpts.Stdout, &dryRunStdout)
+		if err = cmdutil.Run(ctx, []*exec.Cmd{dryRunCmd}, dryRunCmdOpts); err != nil {
+			return res, err
+		}
+		res.Changes = rsync.ParseItemized(dryRunStdout.Bytes())
+		if strings.TrimSpace(dryRunStdout.String()) == "" {
+			slog.InfoContext(ctx, "⬅️Nothing to sync back", "src", rsyncSrc, "dst", rsyncDst)
+			res.Decision = SyncBackNothing
+			return res, nil
+		}
+		// TODO: print a warning if rsyncSrc is newer than rsyncDst
+		plan := cmdutil.NewPlan(cmdutil.PlanSyncBack, []*exec.Cmd{rsyncCmd})
+		plan.Changes = &res.Changes
+		if err = confirmer.Confirm(ctx, plan); err != nil {
+			return res, err
+		}
+	}
+	slog.InfoContext(ctx, "⬅️Syncing the files back", "src", rsyncSrc, "dst", rsyncDst)
+	// Already confirmed
+	rsyncCmdOpts, err := cmdutil.RunOptsFromCobraNoStdin(cmd)
+	if err != nil {
+		return res, err
+	}
+	var rsyncStdout bytes.Buffer
+	rsyncCmdOpts.Stdout = io.MultiWriter(rsyncCmdOpts.Stdout, &rsyncStdout)
+	if err = cmdutil.Run(ctx, []*exec.Cmd{rsyncCmd}, rsyncCmdOpts); err != nil {
+		return res, err
+	}
+	if dryRun {
+		res.Decision = SyncBackSkipped
+		return res, nil
+	}
+	res.Decision = SyncBackApplied
+	res.Changes = rsync.ParseItemized(rsyncStdout.Bytes())
+	// TODO: create Homebrew wrappers (~alcless_USER_default/brew/bin/foo -> ~/.alcless/default/bin/foo)
+	return res, nil
+}
+
+// planSyncBack returns the changes that would be applied by syncing back, without applying them.
+func planSyncBack(ctx context.Context, instName, guestWD, hostWD string, stderr io.Writer) (rsync.Changes, error) {
+	rsyncSrc := instName + ":" + guestWD + string(os.PathSeparator)
+	rsyncCmd, err := rsync.Cmd(ctx, instName, rsyncSrc, hostWD, rsync.WithDryRun())
+	if err != nil {
+		return rsync.Changes{}, err
+	}
+	var rsyncStdout bytes.Buffer
+	if err = cmdutil.Run(ctx, []*exec.Cmd{rsyncCmd}, &cmdutil.RunOpts{Stdout: &rsyncStdout, Stderr: stderr}); err != nil {
+		return rsync.Changes{}, err
+	}
+	return rsync.ParseItemized(rsyncStdout.Bytes()), nil
+}
+
+// leftoversTimeout is the duration to wait for the leftover processes to exit after SIGTERM.
+const leftoversTimeout = 5 * time.Second
+
+// NewSessionID returns a random ID to be passed to [sudo.WithSession] for killing the leftover processes.
+func NewSessionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// KillLeftovers terminates the processes of instUser that belong to the session (see [sudo.WithSession]).
+// The processes of the other sessions of the same instance are not killed,
+// but the processes that cleared the environment variables are not killed either.
+// Empty session means all the processes of instUser.
+//
+// The error is [*errdefs.KillLeftoversError].
+func KillLeftovers(ctx context.Context, instUser, session string, o ...sudo.Opt) error {
+	if err := killLeftovers(ctx, instUser, session, o...); err != nil {
+		return &errdefs.KillLeftoversError{Err: err}
+	}
+	return nil
+}
+
+func killLeftovers(ctx context.Context, instUser, session string, o ...sudo.Opt) error {
+	if cmdutil.IsDryRun(ctx) {
+		// No process was started
+		return nil
+	}
+	var (
+		leftovers []procutil.Process
+		err       error
+	)
+	if session == "" {
+		leftovers, err = procutil.List(ctx, instUser)
+	} else {
+		leftovers, err = procutil.ListSession(ctx, instUser, session, o...)
+	}
+	if err != nil {
+		return err
+	}
+	if len(leftovers) == 0 {
+		slog.DebugContext(ctx, "No leftover process", "instUser", instUser)
+		return nil
+	}
+	for _, p := range leftovers {
+		slog.InfoContext(ctx, "Terminating a leftover process", "pid", p.PID, "command", p.Command)
+	}
+	remaining, err := procutil.Terminate(ctx, instUser, leftovers, leftoversTimeout, o...)
+	for _, p := range remaining {
+		slog.WarnContext(ctx, "Leftover process is still running", "pid", p.PID, "command", p.Command)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package stop
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/errdefs"
+	"github.com/AkihiroSuda/alcless/pkg/procutil"
+	"github.com/AkihiroSuda/alcless/pkg/store"
+	"github.com/AkihiroSuda/alcless/pkg/userutil"
+)
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "stop INSTANCE",
+		Short:                 "Terminate all the processes of an instance",
+		Long:                  "Terminate all the processes of an instance with SIGTERM, and then with SIGKILL after the timeout.",
+		Args:                  cobra.ExactArgs(1),
+		RunE:                  action,
+		DisableFlagsInUseLine: true,
+	}
+	flags := cmd.Flags()
+	flags.Duration("timeout", 10*time.Second, "duration to wait for the processes to exit before sending SIGKILL")
+	return cmd
+}
+
+func action(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	flags := cmd.Flags()
+	flagTimeout, err := flags.GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+	instName := args[0]
+	if err = store.ValidateName(instName); err != nil {
+		return err
+	}
+	instUser := userutil.UserFromInstance(instName)
+	instUserExists, err := userutil.Exists(instUser)
+	if err != nil {
+		return err
+	}
+	if !instUserExists {
+		return &errdefs.InstanceNotFoundError{Instance: instName}
+	}
+	instCfg, err := store.LoadConfig(instName)
+	if err != nil {
+		return err
+	}
+	procs, err := procutil.List(ctx, instUser)
+	if err != nil {
+		return err
+	}
+	if len(procs) == 0 {
+		slog.InfoContext(ctx, "No process is running", "instance", instName)
+		return nil
+	}
+	for _, p := range procs {
+		slog.InfoContext(ctx, "Terminating a process", "instance", instName, "pid", p.PID, "command", p.Command)
+	}
+	if cmdutil.IsDryRun(ctx) {
+		return cmdutil.Run(ctx, []*exec.Cmd{procutil.KillCmd(ctx, instUser, "TERM", procs, instCfg.SudoOpts()...)}, nil)
+	}
+	remaining, err := procutil.Terminate(ctx, instUser, procs, flagTimeout, instCfg.SudoOpts()...)
+	if err != nil {
+		return err
+	}
+	for _, p := range remaining {
+		slog.WarnContext(ctx, "Process is still running", "instance", instName, "pid", p.PID, "command", p.Command)
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("%d process(es) of instance %q are still running", len(remaining), instName)
+	}
+	slog.InfoContext(ctx, "Stopped", "instance", instName)
+	return nil
+}
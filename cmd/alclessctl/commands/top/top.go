@@ -0,0 +1,96 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package top
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/ps"
+)
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "top [INSTANCE]",
+		Short:                 "Display the processes of instances, sorted by CPU usage",
+		Args:                  cobra.MaximumNArgs(1),
+		RunE:                  action,
+		DisableFlagsInUseLine: true,
+	}
+	flags := cmd.Flags()
+	flags.Duration("interval", 2*time.Second, "refresh interval")
+	flags.IntP("number", "n", 20, "number of processes to display (0 for unlimited)")
+	return cmd
+}
+
+// clearScreen moves the cursor to the top-left corner and clears the screen.
+const clearScreen = "\x1b[H\x1b[2J"
+
+func action(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	stdout := cmd.OutOrStdout()
+	flags := cmd.Flags()
+	flagInterval, err := flags.GetDuration("interval")
+	if err != nil {
+		return err
+	}
+	if flagInterval <= 0 {
+		return fmt.Errorf("invalid interval: %v", flagInterval)
+	}
+	flagNumber, err := flags.GetInt("number")
+	if err != nil {
+		return err
+	}
+	var instName string
+	if len(args) > 0 {
+		instName = args[0]
+	}
+	ticker := time.NewTicker(flagInterval)
+	defer ticker.Stop()
+	for {
+		entries, err := ps.Entries(ctx, instName)
+		if err != nil {
+			return err
+		}
+		slices.SortStableFunc(entries, func(a, b ps.Entry) int {
+			return cmp.Compare(b.CPU, a.CPU)
+		})
+		if flagNumber > 0 && len(entries) > flagNumber {
+			entries = entries[:flagNumber]
+		}
+		// Render the whole screen at once to avoid flickering
+		var buf bytes.Buffer
+		buf.WriteString(clearScreen)
+		fmt.Fprintf(&buf, "%s (refreshing every %v, press Ctrl-C to exit)\n\n", time.Now().Format(time.DateTime), flagInterval)
+		if err = ps.WriteTable(&buf, entries); err != nil {
+			return err
+		}
+		if _, err = stdout.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
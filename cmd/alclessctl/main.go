@@ -9,7 +9,6 @@ import (
 	"errors"
 	"log/slog"
 	"os"
-	"os/exec"
 	"time"
 
 	"github.com/lmittmann/tint"
@@ -18,10 +17,21 @@ import (
 
 	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/create"
 	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/delete"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/finish"
 	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/list"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/logs"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/pool"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/ps"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/replay"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/run"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/sessions"
 	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/shell"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/stop"
+	"github.com/AkihiroSuda/alcless/cmd/alclessctl/commands/top"
 	"github.com/AkihiroSuda/alcless/cmd/alclessctl/version"
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
 	"github.com/AkihiroSuda/alcless/pkg/envutil"
+	"github.com/AkihiroSuda/alcless/pkg/errdefs"
 )
 
 var logLevel = new(slog.LevelVar)
@@ -33,13 +43,8 @@ func main() {
 	})
 	slog.SetDefault(slog.New(logHandler))
 	if err := newRootCommand().Execute(); err != nil {
-		exitCode := 1
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if ps := exitErr.ProcessState; ps != nil {
-				exitCode = ps.ExitCode()
-			}
-		}
-		slog.Error("exiting with an error: " + err.Error())
+		exitCode := errdefs.ExitCode(err)
+		slog.Error("exiting with an error: "+err.Error(), "exitCode", exitCode)
 		os.Exit(exitCode)
 	}
 }
@@ -81,7 +86,11 @@ func newRootCommand() *cobra.Command {
 	// Follows limactl's CLI convention, although "tty" was a sort of misnomer.
 	flags.Bool("tty", term.IsTerminal(int(os.Stdout.Fd())), "enable TUI interactions. Defaults to true when stdout is a terminal. Set to false for automation.")
 	flags.BoolP("yes", "y", false, "Alias of --tty=false")
+	flags.String("confirmer", os.Getenv("ALCLESS_CONFIRMER"),
+		"confirm the privileged commands and the changes to sync back with \"tty\", \"json:fd:N\", \"json:unix:PATH\", or \"policy:PATH\" (default: \"tty\" if --tty is true) [$ALCLESS_CONFIRMER]")
 	flags.Bool("plain", false, "plain mode (no Homebrew integration, file syncing, etc.)")
+	flags.String("dry-run", "", "print the commands with the users that run them, instead of running them (\"text\" or \"json\")")
+	flags.Lookup("dry-run").NoOptDefVal = string(cmdutil.DryRunText)
 
 	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
 		flags := cmd.Flags()
@@ -102,6 +111,17 @@ func newRootCommand() *cobra.Command {
 				}
 			}
 		}
+		if flags.Changed("dry-run") {
+			format, err := flags.GetString("dry-run")
+			if err != nil {
+				return err
+			}
+			ctx, err := cmdutil.WithDryRun(cmd.Context(), cmd.OutOrStdout(), cmdutil.DryRunFormat(format))
+			if err != nil {
+				return err
+			}
+			cmd.SetContext(ctx)
+		}
 		return nil
 	}
 
@@ -110,6 +130,15 @@ func newRootCommand() *cobra.Command {
 		create.New(),
 		delete.New(),
 		shell.New(),
+		run.New(),
+		ps.New(),
+		top.New(),
+		stop.New(),
+		sessions.New(),
+		logs.New(),
+		finish.New(),
+		replay.New(),
+		pool.New(),
 	)
 	return cmd
 }
@@ -0,0 +1,129 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/AkihiroSuda/alcless/pkg/errdefs"
+	"github.com/AkihiroSuda/alcless/pkg/userutil"
+)
+
+// setupFake replaces the user accounts and the instance directories with fakes.
+func setupFake(t *testing.T) *userutil.Fake {
+	t.Setenv("ALCLESS_HOME", t.TempDir())
+	fake := userutil.NewFake(t.TempDir())
+	orig := userutil.Default
+	userutil.Default = fake
+	t.Cleanup(func() { userutil.Default = orig })
+	return fake
+}
+
+// alclessctl executes the root command with args, and returns the stdout.
+func alclessctl(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	cmd := newRootCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+func instances(t *testing.T) []string {
+	t.Helper()
+	stdout, err := alclessctl(t, "list", "--quiet")
+	assert.NilError(t, err)
+	return strings.Fields(stdout)
+}
+
+func TestCreateListDelete(t *testing.T) {
+	setupFake(t)
+
+	_, err := alclessctl(t, "--plain", "--yes", "--dry-run", "create", "foo")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(instances(t)))
+
+	_, err = alclessctl(t, "--plain", "--yes", "create", "foo")
+	assert.NilError(t, err)
+	_, err = alclessctl(t, "--plain", "--yes", "create", "--allow=/usr/bin/make", "bar")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"bar", "foo"}, instances(t))
+
+	// Nothing is done for the existing instance, except for the sudoers
+	_, err = alclessctl(t, "--plain", "--yes", "create", "--allow=/usr/bin/env", "foo")
+	assert.NilError(t, err)
+	stdout, err := alclessctl(t, "list")
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(stdout, "restricted (env)"), stdout)
+	assert.Assert(t, strings.Contains(stdout, "restricted (make)"), stdout)
+
+	_, err = alclessctl(t, "--yes", "delete", "foo")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"bar"}, instances(t))
+	_, err = alclessctl(t, "--yes", "--dry-run", "delete", "bar")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"bar"}, instances(t))
+}
+
+func TestCreateRestrictedHomebrew(t *testing.T) {
+	setupFake(t)
+
+	_, err := alclessctl(t, "--yes", "create", "--allow=/home/alcless_foo_default/homebrew/bin/brew", "foo")
+	assert.ErrorContains(t, err, "cannot install Homebrew in the restricted sudoers mode")
+	assert.Equal(t, 0, len(instances(t)))
+
+	// The config of the failed creation is not saved
+	_, err = alclessctl(t, "--plain", "--yes", "create", "foo")
+	assert.NilError(t, err)
+	stdout, err := alclessctl(t, "list")
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(stdout, "unrestricted"), stdout)
+
+	_, err = alclessctl(t, "--plain", "--yes", "create", "--allow=/home/alcless_bar_default/homebrew/bin/brew", "bar")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"bar", "foo"}, instances(t))
+}
+
+// TestCreateFromOtherCommands tests the commands that create an instance without the flags of `alclessctl create`.
+func TestCreateFromOtherCommands(t *testing.T) {
+	setupFake(t)
+
+	_, err := alclessctl(t, "--plain", "--yes", "pool", "size", "1")
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(instances(t)))
+
+	// The command itself fails, as the instance user is fake, but the instance is created
+	_, err = alclessctl(t, "--plain", "--yes", "run", "--name=foo", "true")
+	var cmdErr *errdefs.CommandError
+	assert.Assert(t, errors.As(err, &cmdErr), err)
+	assert.Assert(t, slices.Contains(instances(t), "foo"))
+
+	// The instance is deleted after the command fails
+	_, err = alclessctl(t, "--plain", "--yes", "run", "--rm", "--name=bar", "true")
+	assert.Assert(t, errors.As(err, &cmdErr), err)
+	assert.Assert(t, !strings.Contains(err.Error(), "failed to delete the ephemeral instance"), err)
+	assert.Assert(t, !slices.Contains(instances(t), "bar"))
+}
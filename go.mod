@@ -6,9 +6,11 @@ go 1.25.0
 require (
 	al.essio.dev/pkg/shellescape v1.6.0
 	github.com/containerd/containerd/v2 v2.2.3
+	github.com/creack/pty v1.1.24
 	github.com/lmittmann/tint v1.1.3
 	github.com/sethvargo/go-password v0.3.1
 	github.com/spf13/cobra v1.10.2 // gomodjail:unconfined
+	go.yaml.in/yaml/v3 v3.0.4
 	golang.org/x/term v0.42.0
 	gotest.tools/v3 v3.5.2
 )
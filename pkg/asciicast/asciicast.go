@@ -0,0 +1,263 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package asciicast implements the asciicast v2 format.
+//
+// https://docs.asciinema.org/manual/asciicast/v2/
+package asciicast
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Header is the first line of an asciicast v2 file.
+type Header struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Command       string            `json:"command,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+type EventType string
+
+const (
+	EventTypeOutput = EventType("o")
+	EventTypeInput  = EventType("i")
+)
+
+// Event is an event line, encoded as `[time, type, data]`.
+type Event struct {
+	// Time is the duration since the beginning of the recording.
+	Time time.Duration
+	Type EventType
+	Data string
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{e.Time.Seconds(), e.Type, e.Data})
+}
+
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var (
+		sec float64
+		typ EventType
+	)
+	tuple := []any{&sec, &typ, &e.Data}
+	if err := json.Unmarshal(b, &tuple); err != nil {
+		return err
+	}
+	if len(tuple) != 3 {
+		return fmt.Errorf("expected an event to have 3 elements, got %d", len(tuple))
+	}
+	e.Time = time.Duration(sec * float64(time.Second))
+	e.Type = typ
+	return nil
+}
+
+// Writer writes an asciicast v2 stream.
+// Writer is safe for concurrent use.
+type Writer struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	started time.Time
+}
+
+// NewWriter writes the header to w and returns a Writer.
+// If the timestamp of the header is zero, it is set to the current time.
+func NewWriter(w io.Writer, hdr Header) (*Writer, error) {
+	hdr.Version = 2
+	started := time.Now()
+	if hdr.Timestamp == 0 {
+		hdr.Timestamp = started.Unix()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(hdr); err != nil {
+		return nil, err
+	}
+	return &Writer{enc: enc, started: started}, nil
+}
+
+// WriteEvent writes an event that happens now.
+func (w *Writer) WriteEvent(typ EventType, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(Event{Time: time.Since(w.started), Type: typ, Data: string(data)})
+}
+
+// EventWriter returns an [io.WriteCloser] that writes the data as events of typ.
+//
+// An incomplete UTF-8 sequence at the end of the data is held until the next write,
+// so that a multibyte character split across writes is not encoded as U+FFFD.
+// Close writes the held bytes, if any.
+func (w *Writer) EventWriter(typ EventType) io.WriteCloser {
+	return &eventWriter{w: w, typ: typ}
+}
+
+type eventWriter struct {
+	w       *Writer
+	typ     EventType
+	mu      sync.Mutex
+	pending []byte
+}
+
+func (ew *eventWriter) Write(p []byte) (int, error) {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	data := append(ew.pending, p...)
+	n := len(data) - incompleteSuffix(data)
+	ew.pending = bytes.Clone(data[n:])
+	if n == 0 {
+		return len(p), nil
+	}
+	if err := ew.w.WriteEvent(ew.typ, data[:n]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (ew *eventWriter) Close() error {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if len(ew.pending) == 0 {
+		return nil
+	}
+	data := ew.pending
+	ew.pending = nil
+	return ew.w.WriteEvent(ew.typ, data)
+}
+
+// incompleteSuffix returns the length of the incomplete UTF-8 sequence at the end of b.
+func incompleteSuffix(b []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	// Not a valid sequence, written as is
+	return 0
+}
+
+// Reader reads an asciicast v2 stream.
+type Reader struct {
+	Header  Header
+	scanner *bufio.Scanner
+}
+
+// NewReader reads the header from r and returns a Reader.
+func NewReader(r io.Reader) (*Reader, error) {
+	scanner := bufio.NewScanner(r)
+	// An event may contain a large chunk of the output
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("missing asciicast header")
+	}
+	var hdr Header
+	if err := json.Unmarshal(scanner.Bytes(), &hdr); err != nil {
+		return nil, fmt.Errorf("failed to parse the asciicast header: %w", err)
+	}
+	if hdr.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", hdr.Version)
+	}
+	return &Reader{Header: hdr, scanner: scanner}, nil
+}
+
+// Next returns the next event, or [io.EOF].
+func (r *Reader) Next() (*Event, error) {
+	for r.scanner.Scan() {
+		b := r.scanner.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal(b, &ev); err != nil {
+			return nil, fmt.Errorf("failed to parse an asciicast event %q: %w", string(b), err)
+		}
+		return &ev, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+type PlayOpts struct {
+	// Speed is the playback speed. Defaults to 1.0.
+	Speed float64
+	// IdleTimeLimit limits the idle time between the events.
+	// Defaults to the idle time limit in the header. Zero means no limit.
+	IdleTimeLimit time.Duration
+}
+
+// Play writes the output events from r to w, with the recorded timing.
+func Play(ctx context.Context, r *Reader, w io.Writer, opts PlayOpts) error {
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1.0
+	}
+	idleTimeLimit := opts.IdleTimeLimit
+	if idleTimeLimit == 0 && r.Header.IdleTimeLimit > 0 {
+		idleTimeLimit = time.Duration(r.Header.IdleTimeLimit * float64(time.Second))
+	}
+	var prev time.Duration
+	for {
+		ev, err := r.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		delay := ev.Time - prev
+		prev = ev.Time
+		if idleTimeLimit > 0 {
+			delay = min(delay, idleTimeLimit)
+		}
+		delay = time.Duration(float64(delay) / speed)
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		if ev.Type != EventTypeOutput {
+			continue
+		}
+		if _, err = io.WriteString(w, ev.Data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package asciicast
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestWriterReader(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Header{Width: 80, Height: 24, Command: "echo hello"})
+	assert.NilError(t, err)
+	_, err = fmt.Fprint(w.EventWriter(EventTypeOutput), "hello\r\n")
+	assert.NilError(t, err)
+	assert.NilError(t, w.WriteEvent(EventTypeInput, []byte("q")))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Assert(t, strings.HasPrefix(lines[0], `{"version":2,"width":80,"height":24,"timestamp":`), lines[0])
+	assert.Assert(t, strings.HasSuffix(lines[1], `,"o","hello\r\n"]`), lines[1])
+
+	r, err := NewReader(&buf)
+	assert.NilError(t, err)
+	assert.Equal(t, "echo hello", r.Header.Command)
+	ev, err := r.Next()
+	assert.NilError(t, err)
+	assert.Equal(t, EventTypeOutput, ev.Type)
+	assert.Equal(t, "hello\r\n", ev.Data)
+	ev, err = r.Next()
+	assert.NilError(t, err)
+	assert.Equal(t, EventTypeInput, ev.Type)
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEventWriterSplitUTF8(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Header{Width: 80, Height: 24})
+	assert.NilError(t, err)
+	ew := w.EventWriter(EventTypeOutput)
+	b := []byte("こんにちは")
+	// Split in the middle of the first and the last characters
+	for _, chunk := range [][]byte{b[:1], b[1:13], b[13:]} {
+		_, err = ew.Write(chunk)
+		assert.NilError(t, err)
+	}
+	// Incomplete at the end of the stream
+	_, err = ew.Write([]byte("!\xe3\x81"))
+	assert.NilError(t, err)
+	assert.NilError(t, ew.Close())
+
+	r, err := NewReader(&buf)
+	assert.NilError(t, err)
+	var data []string
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NilError(t, err)
+		data = append(data, ev.Data)
+	}
+	assert.DeepEqual(t, []string{"こんにち", "は", "!", "\ufffd\ufffd"}, data)
+}
+
+func TestPlay(t *testing.T) {
+	const cast = `{"version": 2, "width": 80, "height": 24, "idle_time_limit": 0.01}
+[0.0, "o", "foo"]
+[0.5, "i", "x"]
+[100.0, "o", "bar\r\n"]
+`
+	r, err := NewReader(strings.NewReader(cast))
+	assert.NilError(t, err)
+	var out bytes.Buffer
+	started := time.Now()
+	assert.NilError(t, Play(context.Background(), r, &out, PlayOpts{Speed: 2}))
+	assert.Assert(t, time.Since(started) < 10*time.Second)
+	assert.Equal(t, "foobar\r\n", out.String())
+
+	_, err = NewReader(strings.NewReader(`{"version": 1}`))
+	assert.ErrorContains(t, err, "unsupported asciicast version")
+}
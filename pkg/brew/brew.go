@@ -28,11 +28,11 @@ import (
 	"github.com/AkihiroSuda/alcless/pkg/sudo"
 )
 
-func InstalledCmd(ctx context.Context, instUser, homeDir string) *exec.Cmd {
-	return sudo.Cmd(ctx, instUser, "", filepath.Join(homeDir, "homebrew/bin/brew"), []string{"--version"})
+func InstalledCmd(ctx context.Context, instUser, homeDir string, o ...sudo.Opt) *exec.Cmd {
+	return sudo.Cmd(ctx, instUser, "", filepath.Join(homeDir, "homebrew/bin/brew"), []string{"--version"}, o...)
 }
 
-func Installed(ctx context.Context, instUser string) error {
+func Installed(ctx context.Context, instUser string, o ...sudo.Opt) error {
 	instUserInfo, err := user.Lookup(instUser)
 	if err != nil {
 		return err
@@ -41,7 +41,7 @@ func Installed(ctx context.Context, instUser string) error {
 		return fmt.Errorf("user %q does not have the home directory", instUser)
 	}
 	var stderr bytes.Buffer
-	cmd := InstalledCmd(ctx, instUser, instUserInfo.HomeDir)
+	cmd := InstalledCmd(ctx, instUser, instUserInfo.HomeDir, o...)
 	cmd.Stderr = &stderr
 	slog.DebugContext(ctx, "Running command", "cmd", cmd.Args)
 	b, err := cmd.Output()
@@ -52,15 +52,15 @@ func Installed(ctx context.Context, instUser string) error {
 	return nil
 }
 
-func InstallCmds(ctx context.Context, instUser string) []*exec.Cmd {
+func InstallCmds(ctx context.Context, instUser string, o ...sudo.Opt) []*exec.Cmd {
 	cmds := []*exec.Cmd{
 		// Remove system-wide Homebrew (/opt/homebrew/bin) from the PATH
 		// Needed since Homebrew 4.5.9 (July 8, 2025)
 		// https://github.com/AkihiroSuda/alcless/issues/23
-		sudo.Cmd(ctx, instUser, "", "sh", []string{"-c", `echo 'PATH="$(echo "$PATH" | sed -e s@/opt/homebrew/bin:@@g)"; export PATH' | tee -a "${HOME}/.bash_profile" | tee -a "${HOME}/.bashrc" | tee -a "${HOME}/.zprofile" >> "${HOME}/.zshenv"`}),
+		sudo.Cmd(ctx, instUser, "", "sh", []string{"-c", `echo 'PATH="$(echo "$PATH" | sed -e s@/opt/homebrew/bin:@@g)"; export PATH' | tee -a "${HOME}/.bash_profile" | tee -a "${HOME}/.bashrc" | tee -a "${HOME}/.zprofile" >> "${HOME}/.zshenv"`}, o...),
 
-		sudo.Cmd(ctx, instUser, "", "git", []string{"clone", "https://github.com/Homebrew/brew", "homebrew"}),
-		sudo.Cmd(ctx, instUser, "", "sh", []string{"-c", `echo 'eval "$("${HOME}/homebrew/bin/brew" shellenv)"' | tee -a "${HOME}/.bash_profile" >> "${HOME}/.zshenv"`}),
+		sudo.Cmd(ctx, instUser, "", "git", []string{"clone", "https://github.com/Homebrew/brew", "homebrew"}, o...),
+		sudo.Cmd(ctx, instUser, "", "sh", []string{"-c", `echo 'eval "$("${HOME}/homebrew/bin/brew" shellenv)"' | tee -a "${HOME}/.bash_profile" >> "${HOME}/.zshenv"`}, o...),
 	}
 	return cmds
 }
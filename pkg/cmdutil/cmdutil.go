@@ -19,6 +19,7 @@ package cmdutil
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -29,24 +30,50 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// ErrAborted is returned when the user did not confirm the commands.
+var ErrAborted = errors.New("aborted by the user")
+
 type RunOpts struct {
-	Confirm bool
-	Stdin   io.Reader
-	Stdout  io.Writer
-	Stderr  io.Writer
+	// Confirmer confirms the commands before running them.
+	// Nil runs the commands without confirmation.
+	Confirmer Confirmer
+	Stdin     io.Reader
+	Stdout    io.Writer
+	Stderr    io.Writer
 }
 
-func RunOptsFromCobra(cmd *cobra.Command) (*RunOpts, error) {
+// ConfirmerFromCobra returns the confirmer specified with --confirmer.
+// The terminal confirmer is returned if --confirmer is not specified and --tty is true.
+// Otherwise nil is returned.
+func ConfirmerFromCobra(cmd *cobra.Command) (Confirmer, error) {
 	flags := cmd.Flags()
+	confirmer, err := flags.GetString("confirmer")
+	if err != nil {
+		return nil, err
+	}
+	if confirmer != "" {
+		return ParseConfirmer(confirmer, cmd.ErrOrStderr())
+	}
 	tty, err := flags.GetBool("tty")
 	if err != nil {
 		return nil, err
 	}
+	if !tty {
+		return nil, nil
+	}
+	return ParseConfirmer("tty", cmd.ErrOrStderr())
+}
+
+func RunOptsFromCobra(cmd *cobra.Command) (*RunOpts, error) {
+	confirmer, err := ConfirmerFromCobra(cmd)
+	if err != nil {
+		return nil, err
+	}
 	return &RunOpts{
-		Confirm: tty,
-		Stdin:   cmd.InOrStdin(),
-		Stdout:  cmd.OutOrStdout(),
-		Stderr:  cmd.ErrOrStderr(),
+		Confirmer: confirmer,
+		Stdin:     cmd.InOrStdin(),
+		Stdout:    cmd.OutOrStdout(),
+		Stderr:    cmd.ErrOrStderr(),
 	}, nil
 }
 
@@ -68,21 +95,18 @@ func Run(ctx context.Context, cmds []*exec.Cmd, opts *RunOpts) error {
 	if opts.Stderr != nil {
 		stderr = opts.Stderr
 	}
-	if opts.Confirm {
-		fmt.Fprintln(stderr, "⚠️  The following commands will be executed:")
-		for _, c := range cmds {
-			fmt.Fprintln(stderr, shellescape.QuoteCommand(c.Args))
-		}
-		fmt.Fprintln(stderr, "❓ Press return to continue, or Ctrl-C to abort")
-		if _, err := fmt.Scanln(); err != nil {
+	if d, ok := ctx.Value(dryRunKey{}).(*dryRun); ok {
+		return d.print(cmds)
+	}
+	if opts.Confirmer != nil {
+		if err := opts.Confirmer.Confirm(ctx, NewPlan(PlanCommands, cmds)); err != nil {
 			return err
 		}
-		fmt.Fprintln(stderr, "CONTINUE")
 	}
 
 	for _, c := range cmds {
 		argsEscaped := shellescape.QuoteCommand(c.Args)
-		if opts.Confirm && len(cmds) > 1 {
+		if opts.Confirmer != nil && len(cmds) > 1 {
 			// Always the progress when running multiple destructive commands
 			slog.InfoContext(ctx, "Running command", "cmd", argsEscaped)
 		} else {
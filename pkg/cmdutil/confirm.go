@@ -0,0 +1,355 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"al.essio.dev/pkg/shellescape"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/AkihiroSuda/alcless/pkg/rsync"
+)
+
+type PlanKind string
+
+const (
+	// PlanCommands is a plan of running potentially destructive commands.
+	PlanCommands = PlanKind("commands")
+	// PlanSyncBack is a plan of syncing back the files from an instance to the host.
+	PlanSyncBack = PlanKind("syncBack")
+	// PlanMountRO is a plan of copying the host directories into an instance, as requested by a project config,
+	// which is not trusted as it is a part of the repository content.
+	PlanMountRO = PlanKind("mountRO")
+)
+
+// Plan is the structured plan to be confirmed by a [Confirmer].
+type Plan struct {
+	Kind     PlanKind         `json:"kind"`
+	Commands []PlannedCommand `json:"commands"`
+	// Changes are the changes to be applied to the host. Only set for [PlanSyncBack].
+	Changes *rsync.Changes `json:"changes,omitempty"`
+	// Paths are the host paths to be copied. Only set for [PlanMountRO].
+	Paths []string `json:"paths,omitempty"`
+}
+
+type PlannedCommand struct {
+	Args []string `json:"args"`
+	Dir  string   `json:"dir,omitempty"`
+}
+
+// NewPlan returns a plan of running cmds.
+func NewPlan(kind PlanKind, cmds []*exec.Cmd) *Plan {
+	plan := &Plan{Kind: kind}
+	for _, c := range cmds {
+		plan.Commands = append(plan.Commands, PlannedCommand{Args: c.Args, Dir: c.Dir})
+	}
+	return plan
+}
+
+// Confirmer confirms a plan before it is executed.
+type Confirmer interface {
+	// Confirm returns nil if the plan is approved.
+	// An error wrapping [ErrAborted] is returned if the plan is denied.
+	Confirm(ctx context.Context, plan *Plan) error
+}
+
+// TerminalConfirmer shows the plan, and asks the user to press return in the terminal.
+// See [ReadAnswer] for the terminal.
+type TerminalConfirmer struct {
+	Stderr io.Writer
+}
+
+func (c *TerminalConfirmer) Confirm(_ context.Context, plan *Plan) error {
+	stderr := c.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	switch {
+	case plan.Kind == PlanSyncBack && plan.Changes != nil:
+		// The itemized changes have been already printed by the dry run
+		fmt.Fprintf(stderr, "⚠️  The following changes will be applied (created: %d, modified: %d, deleted: %d):\n",
+			len(plan.Changes.Created), len(plan.Changes.Modified), len(plan.Changes.Deleted))
+	case plan.Kind == PlanMountRO:
+		fmt.Fprintln(stderr, "⚠️  The project config requests copying the following host directories into the instance:")
+		for _, p := range plan.Paths {
+			fmt.Fprintln(stderr, p)
+		}
+	default:
+		fmt.Fprintln(stderr, "⚠️  The following commands will be executed:")
+	}
+	for _, c := range plan.Commands {
+		fmt.Fprintln(stderr, shellescape.QuoteCommand(c.Args))
+	}
+	fmt.Fprintln(stderr, "❓ Press return to continue, or Ctrl-C to abort")
+	if _, err := ReadAnswer(); err != nil {
+		if errors.Is(err, ErrNoTerminal) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrAborted, err)
+	}
+	fmt.Fprintln(stderr, "CONTINUE")
+	return nil
+}
+
+// JSONRequest is written by [JSONConfirmer] as a line.
+type JSONRequest struct {
+	ID   int   `json:"id"`
+	Plan *Plan `json:"plan"`
+}
+
+// JSONResponse is read by [JSONConfirmer] as a line.
+type JSONResponse struct {
+	ID       int    `json:"id"`
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// JSONConfirmer sends the plan as a [JSONRequest] line, and receives a [JSONResponse] line.
+// This is useful for integrating with IDEs and agent harnesses.
+type JSONConfirmer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	r      *bufio.Reader
+	nextID int
+}
+
+func NewJSONConfirmer(rw io.ReadWriter) *JSONConfirmer {
+	return &JSONConfirmer{w: rw, r: bufio.NewReader(rw), nextID: 1}
+}
+
+func (c *JSONConfirmer) Confirm(_ context.Context, plan *Plan) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	req := JSONRequest{ID: c.nextID, Plan: plan}
+	c.nextID++
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err = c.w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to send the confirmation request: %w", err)
+	}
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("%w: failed to receive the confirmation response: %w", ErrAborted, err)
+	}
+	var resp JSONResponse
+	if err = json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("failed to parse the confirmation response %q: %w", string(line), err)
+	}
+	if resp.ID != req.ID {
+		return fmt.Errorf("expected the confirmation response for request %d, got %d", req.ID, resp.ID)
+	}
+	if !resp.Approved {
+		if resp.Reason != "" {
+			return fmt.Errorf("%w: %s", ErrAborted, resp.Reason)
+		}
+		return ErrAborted
+	}
+	return nil
+}
+
+type PolicyAction string
+
+const (
+	PolicyApprove = PolicyAction("approve")
+	PolicyDeny    = PolicyAction("deny")
+	// PolicyAsk delegates the confirmation to the fallback confirmer.
+	PolicyAsk = PolicyAction("ask")
+)
+
+// PolicyRule is a rule of [Policy].
+type PolicyRule struct {
+	// Kind matches the kind of the plan. Empty matches any kind.
+	Kind PlanKind `yaml:"kind,omitempty"`
+	// CommandPrefix matches the commands that start with the prefix. Empty matches any command.
+	// An "approve" rule matches when all the commands match, and other rules match when any command matches.
+	CommandPrefix []string     `yaml:"commandPrefix,omitempty"`
+	Action        PolicyAction `yaml:"action"`
+}
+
+// Policy is the content of a policy file.
+//
+// Example:
+//
+//	rules:
+//	  - kind: commands
+//	    commandPrefix: [sudo, sysadminctl]
+//	    action: deny
+//	  - kind: syncBack
+//	    action: approve
+//	default: ask
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules,omitempty"`
+	// Default is the action when no rule matches. Defaults to "deny".
+	Default PolicyAction `yaml:"default,omitempty"`
+}
+
+func (p *Policy) Validate() error {
+	for i, r := range p.Rules {
+		switch r.Action {
+		case PolicyApprove, PolicyDeny, PolicyAsk:
+		default:
+			return fmt.Errorf("rule %d: unknown action %q", i, r.Action)
+		}
+	}
+	switch p.Default {
+	case "", PolicyApprove, PolicyDeny, PolicyAsk:
+	default:
+		return fmt.Errorf("unknown default action %q", p.Default)
+	}
+	return nil
+}
+
+// LoadPolicy loads a policy file.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err = dec.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+	if err = p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %q: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (r *PolicyRule) matches(plan *Plan) bool {
+	if r.Kind != "" && r.Kind != plan.Kind {
+		return false
+	}
+	if len(r.CommandPrefix) == 0 {
+		return true
+	}
+	hasPrefix := func(c PlannedCommand) bool {
+		return len(c.Args) >= len(r.CommandPrefix) && slices.Equal(c.Args[:len(r.CommandPrefix)], r.CommandPrefix)
+	}
+	if r.Action == PolicyApprove {
+		return len(plan.Commands) > 0 && !slices.ContainsFunc(plan.Commands, func(c PlannedCommand) bool { return !hasPrefix(c) })
+	}
+	return slices.ContainsFunc(plan.Commands, hasPrefix)
+}
+
+// PolicyConfirmer approves or denies the plan with a [Policy], without interaction.
+type PolicyConfirmer struct {
+	Policy *Policy
+	// Fallback is used for the "ask" action. Nil denies the plan.
+	Fallback Confirmer
+}
+
+func (c *PolicyConfirmer) Confirm(ctx context.Context, plan *Plan) error {
+	action := c.Policy.Default
+	if action == "" {
+		action = PolicyDeny
+	}
+	for _, r := range c.Policy.Rules {
+		if r.matches(plan) {
+			action = r.Action
+			break
+		}
+	}
+	switch action {
+	case PolicyApprove:
+		return nil
+	case PolicyAsk:
+		if c.Fallback != nil {
+			return c.Fallback.Confirm(ctx, plan)
+		}
+	}
+	return fmt.Errorf("%w: denied by the policy", ErrAborted)
+}
+
+var (
+	jsonConfirmers   = make(map[string]*JSONConfirmer)
+	jsonConfirmersMu sync.Mutex
+)
+
+// ParseConfirmer returns the confirmer for the spec:
+//
+//   - "tty": [TerminalConfirmer]
+//   - "json:fd:N": [JSONConfirmer] over the file descriptor N (e.g., a socket pair)
+//   - "json:unix:PATH": [JSONConfirmer] over the UNIX socket
+//   - "policy:PATH": [PolicyConfirmer] with the policy file, falling back to [TerminalConfirmer] for "ask"
+//
+// The [JSONConfirmer] is cached, so that the connection is shared in the process.
+// The other confirmers are created for each call, as they write to stderr of the caller.
+func ParseConfirmer(spec string, stderr io.Writer) (Confirmer, error) {
+	c, err := parseConfirmer(spec, stderr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid confirmer %q: %w", spec, err)
+	}
+	return c, nil
+}
+
+func parseConfirmer(spec string, stderr io.Writer) (Confirmer, error) {
+	if spec == "tty" {
+		return &TerminalConfirmer{Stderr: stderr}, nil
+	}
+	if p, ok := strings.CutPrefix(spec, "policy:"); ok {
+		policy, err := LoadPolicy(p)
+		if err != nil {
+			return nil, err
+		}
+		return &PolicyConfirmer{Policy: policy, Fallback: &TerminalConfirmer{Stderr: stderr}}, nil
+	}
+	jsonConfirmersMu.Lock()
+	defer jsonConfirmersMu.Unlock()
+	if c, ok := jsonConfirmers[spec]; ok {
+		return c, nil
+	}
+	var rw io.ReadWriter
+	if fd, ok := strings.CutPrefix(spec, "json:fd:"); ok {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, err
+		}
+		if n <= 2 {
+			return nil, fmt.Errorf("file descriptor must be larger than 2, got %d", n)
+		}
+		rw = os.NewFile(uintptr(n), "confirmer")
+	} else if p, ok := strings.CutPrefix(spec, "json:unix:"); ok {
+		conn, err := net.Dial("unix", p)
+		if err != nil {
+			return nil, err
+		}
+		rw = conn
+	} else {
+		return nil, errors.New("expected \"tty\", \"json:fd:N\", \"json:unix:PATH\", or \"policy:PATH\"")
+	}
+	c := NewJSONConfirmer(rw)
+	jsonConfirmers[spec] = c
+	return c, nil
+}
@@ -0,0 +1,139 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/AkihiroSuda/alcless/pkg/rsync"
+)
+
+func TestJSONConfirmer(t *testing.T) {
+	ctx := context.Background()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		r := bufio.NewReader(server)
+		for {
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			var req JSONRequest
+			if err = json.Unmarshal(line, &req); err != nil {
+				return
+			}
+			// Approve the plans without deletion
+			resp := JSONResponse{ID: req.ID, Approved: req.Plan.Changes == nil || len(req.Plan.Changes.Deleted) == 0}
+			if !resp.Approved {
+				resp.Reason = "deletion is not allowed"
+			}
+			b, _ := json.Marshal(resp)
+			if _, err = server.Write(append(b, '\n')); err != nil {
+				return
+			}
+		}
+	}()
+	c := NewJSONConfirmer(client)
+	plan := &Plan{Kind: PlanSyncBack, Changes: &rsync.Changes{Created: []string{"foo"}}}
+	assert.NilError(t, c.Confirm(ctx, plan))
+	plan.Changes.Deleted = []string{"bar"}
+	err := c.Confirm(ctx, plan)
+	assert.ErrorIs(t, err, ErrAborted)
+	assert.ErrorContains(t, err, "deletion is not allowed")
+}
+
+func TestPolicyConfirmer(t *testing.T) {
+	ctx := context.Background()
+	policyPath := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.NilError(t, os.WriteFile(policyPath, []byte(`rules:
+  - commandPrefix: [sudo, rm]
+    action: deny
+  - kind: commands
+    commandPrefix: [sudo]
+    action: approve
+  - kind: syncBack
+    action: approve
+`), 0o644))
+	policy, err := LoadPolicy(policyPath)
+	assert.NilError(t, err)
+	c := &PolicyConfirmer{Policy: policy}
+
+	plan := func(kind PlanKind, args ...[]string) *Plan {
+		p := &Plan{Kind: kind}
+		for _, a := range args {
+			p.Commands = append(p.Commands, PlannedCommand{Args: a})
+		}
+		return p
+	}
+	assert.NilError(t, c.Confirm(ctx, plan(PlanCommands, []string{"sudo", "sysadminctl"}, []string{"sudo", "chmod"})))
+	assert.ErrorIs(t, c.Confirm(ctx, plan(PlanCommands, []string{"sudo", "sysadminctl"}, []string{"sudo", "rm", "-f", "foo"})), ErrAborted)
+	// Not all the commands match the approve rule
+	assert.ErrorIs(t, c.Confirm(ctx, plan(PlanCommands, []string{"sudo", "chmod"}, []string{"chmod"})), ErrAborted)
+	assert.NilError(t, c.Confirm(ctx, plan(PlanSyncBack, []string{"rsync"})))
+	// Not matched by the rules for the commands
+	assert.ErrorIs(t, c.Confirm(ctx, &Plan{Kind: PlanMountRO, Paths: []string{"/opt/sdk"}}), ErrAborted)
+
+	assert.NilError(t, os.WriteFile(policyPath, []byte("default: maybe\n"), 0o644))
+	_, err = LoadPolicy(policyPath)
+	assert.ErrorContains(t, err, "unknown default action")
+}
+
+func TestParseConfirmer(t *testing.T) {
+	var stderr1, stderr2 bytes.Buffer
+	c1, err := ParseConfirmer("tty", &stderr1)
+	assert.NilError(t, err)
+	c2, err := ParseConfirmer("tty", &stderr2)
+	assert.NilError(t, err)
+	// Not shared across the callers with different stderr
+	assert.Equal(t, io.Writer(&stderr1), c1.(*TerminalConfirmer).Stderr)
+	assert.Equal(t, io.Writer(&stderr2), c2.(*TerminalConfirmer).Stderr)
+
+	policyPath := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.NilError(t, os.WriteFile(policyPath, []byte("default: ask\n"), 0o644))
+	c1, err = ParseConfirmer("policy:"+policyPath, &stderr1)
+	assert.NilError(t, err)
+	c2, err = ParseConfirmer("policy:"+policyPath, &stderr2)
+	assert.NilError(t, err)
+	assert.Equal(t, io.Writer(&stderr1), c1.(*PolicyConfirmer).Fallback.(*TerminalConfirmer).Stderr)
+	assert.Equal(t, io.Writer(&stderr2), c2.(*PolicyConfirmer).Fallback.(*TerminalConfirmer).Stderr)
+
+	// The connection is shared
+	sock := filepath.Join(t.TempDir(), "confirmer.sock")
+	l, err := net.Listen("unix", sock)
+	assert.NilError(t, err)
+	defer l.Close()
+	c1, err = ParseConfirmer("json:unix:"+sock, &stderr1)
+	assert.NilError(t, err)
+	c2, err = ParseConfirmer("json:unix:"+sock, &stderr2)
+	assert.NilError(t, err)
+	assert.Equal(t, c1, c2)
+
+	_, err = ParseConfirmer("foo", &stderr1)
+	assert.ErrorContains(t, err, "invalid confirmer")
+}
@@ -0,0 +1,138 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"al.essio.dev/pkg/shellescape"
+)
+
+type DryRunFormat string
+
+const (
+	DryRunText = DryRunFormat("text")
+	// DryRunJSON prints a [DryRunEntry] per line.
+	DryRunJSON = DryRunFormat("json")
+)
+
+// DryRunEntry is a command printed in the dry-run mode.
+type DryRunEntry struct {
+	// User is the user that runs the command.
+	User string   `json:"user"`
+	Args []string `json:"args"`
+	Dir  string   `json:"dir,omitempty"`
+}
+
+type dryRun struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format DryRunFormat
+}
+
+type dryRunKey struct{}
+
+// WithDryRun returns a context that makes [Run] print the commands to w instead of running them.
+func WithDryRun(ctx context.Context, w io.Writer, format DryRunFormat) (context.Context, error) {
+	switch format {
+	case DryRunText, DryRunJSON:
+	default:
+		return nil, fmt.Errorf("unknown dry-run format %q (expected %q or %q)", format, DryRunText, DryRunJSON)
+	}
+	return context.WithValue(ctx, dryRunKey{}, &dryRun{w: w, format: format}), nil
+}
+
+// IsDryRun returns true if the context is in the dry-run mode.
+// The callers should skip the operations that are not executed via [Run] (e.g., writing files).
+func IsDryRun(ctx context.Context) bool {
+	_, ok := ctx.Value(dryRunKey{}).(*dryRun)
+	return ok
+}
+
+func (d *dryRun) print(cmds []*exec.Cmd) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range cmds {
+		e := DryRunEntry{User: RunAs(c), Args: c.Args, Dir: c.Dir}
+		switch d.format {
+		case DryRunJSON:
+			enc := json.NewEncoder(d.w)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		default:
+			line := shellescape.QuoteCommand(e.Args)
+			if e.Dir != "" {
+				line = "(cd " + shellescape.Quote(e.Dir) + " && " + line + ")"
+			}
+			if _, err := fmt.Fprintf(d.w, "# as %s\n%s\n", e.User, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RunAs returns the user that runs the command.
+// The user of a sudo command is detected from `-u USER`, `su [-] USER`, or `runuser -l USER`.
+// `launchctl asuser UID` before su is skipped.
+// The current user is returned for non-sudo commands.
+func RunAs(cmd *exec.Cmd) string {
+	args := cmd.Args
+	if len(args) == 0 || filepath.Base(args[0]) != "sudo" {
+		if u, err := user.Current(); err == nil {
+			return u.Username
+		}
+		return "unknown"
+	}
+	runAs := "root"
+	i := 1
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		switch {
+		case args[i] == "-u" && i+1 < len(args):
+			i++
+			runAs = args[i]
+		case strings.HasPrefix(args[i], "--user="):
+			runAs = strings.TrimPrefix(args[i], "--user=")
+		}
+	}
+	if runAs == "root" && i+2 < len(args) && filepath.Base(args[i]) == "launchctl" && args[i+1] == "asuser" {
+		i += 3
+	}
+	if runAs != "root" || i >= len(args) || (filepath.Base(args[i]) != "su" && filepath.Base(args[i]) != "runuser") {
+		return runAs
+	}
+	for i++; i < len(args); i++ {
+		if args[i] == "-" || args[i] == "-l" || args[i] == "--login" {
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") {
+			break
+		}
+		return args[i]
+	}
+	return runAs
+}
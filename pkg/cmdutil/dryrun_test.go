@@ -0,0 +1,63 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdutil
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestRunAs(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"sudo", "sysadminctl", "-addUser", "foo"}, "root"},
+		{[]string{"sudo", "-n", "/usr/bin/su", "-", "alcless_me_default", "-c", "true"}, "alcless_me_default"},
+		{[]string{"sudo", "-u", "alcless_me_default", "true"}, "alcless_me_default"},
+		{[]string{"sudo", "--user=alcless_me_default", "true"}, "alcless_me_default"},
+		{[]string{"sudo", "-n", "/usr/sbin/runuser", "-l", "alcless_me_default", "-c", "true"}, "alcless_me_default"},
+		{[]string{"sudo", "-n", "/bin/launchctl", "asuser", "501", "/usr/bin/su", "-", "alcless_me_default", "-c", "true"}, "alcless_me_default"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, RunAs(exec.Command(tc.args[0], tc.args[1:]...)), "args=%v", tc.args)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	var buf bytes.Buffer
+	ctx, err := WithDryRun(context.Background(), &buf, DryRunText)
+	assert.NilError(t, err)
+	assert.Assert(t, IsDryRun(ctx))
+	cmds := []*exec.Cmd{
+		exec.CommandContext(ctx, "sudo", "sh", "-c", "echo foo > /etc/foo"),
+	}
+	// Confirmer is not called in the dry-run mode
+	assert.NilError(t, Run(ctx, cmds, &RunOpts{Confirmer: &PolicyConfirmer{Policy: &Policy{}}}))
+	assert.Equal(t, "# as root\nsudo sh -c 'echo foo > /etc/foo'\n", buf.String())
+
+	buf.Reset()
+	ctx, err = WithDryRun(context.Background(), &buf, DryRunJSON)
+	assert.NilError(t, err)
+	assert.NilError(t, Run(ctx, cmds, nil))
+	assert.Equal(t, `{"user":"root","args":["sudo","sh","-c","echo foo > /etc/foo"]}`, strings.TrimSpace(buf.String()))
+}
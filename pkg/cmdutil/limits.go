@@ -0,0 +1,70 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdutil
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+)
+
+// AddLimitsFlags adds the flags for [sudo.Limits].
+func AddLimitsFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringArray("ulimit", nil, fmt.Sprintf("resource limit in the form of NAME=VALUE (NAME: %s)", strings.Join(sudo.UlimitNames(), ", ")))
+	flags.Int("nice", 0, "niceness (0-20)")
+	flags.Bool("background", false, "run with the background QoS (macOS only)")
+}
+
+// LimitsFromCobra returns base overridden by the flags added by [AddLimitsFlags].
+func LimitsFromCobra(cmd *cobra.Command, base sudo.Limits) (*sudo.Limits, error) {
+	flags := cmd.Flags()
+	limits := base
+	limits.Ulimits = maps.Clone(base.Ulimits)
+	flagUlimit, err := flags.GetStringArray("ulimit")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range flagUlimit {
+		k, v, err := sudo.ParseUlimit(f)
+		if err != nil {
+			return nil, err
+		}
+		if limits.Ulimits == nil {
+			limits.Ulimits = make(map[string]uint64)
+		}
+		limits.Ulimits[k] = v
+	}
+	if flags.Changed("nice") {
+		if limits.Nice, err = flags.GetInt("nice"); err != nil {
+			return nil, err
+		}
+	}
+	if flags.Changed("background") {
+		if limits.Background, err = flags.GetBool("background"); err != nil {
+			return nil, err
+		}
+	}
+	if err = limits.Validate(); err != nil {
+		return nil, err
+	}
+	return &limits, nil
+}
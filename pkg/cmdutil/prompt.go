@@ -0,0 +1,57 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ErrNoTerminal is returned when a prompt cannot be answered, as no terminal is available.
+var ErrNoTerminal = errors.New("no terminal is available for the confirmation prompt (Hint: specify --tty=false to skip the confirmation)")
+
+// ttyPath is the path of the controlling terminal.
+const ttyPath = "/dev/tty"
+
+// ReadAnswer reads a line from the terminal, for answering a prompt.
+//
+// The stdin is used if it is a terminal.
+// Otherwise the controlling terminal (/dev/tty) is used, so that the prompt does not consume
+// the stdin piped to the command (e.g., `cat data.csv | alcless python process.py`).
+// [ErrNoTerminal] is returned if neither is available.
+func ReadAnswer() (string, error) {
+	var r io.Reader = os.Stdin
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		tty, err := os.Open(ttyPath)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrNoTerminal, err)
+		}
+		defer tty.Close()
+		r = tty
+	}
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,96 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"al.essio.dev/pkg/shellescape"
+)
+
+// Step is a command with a human-readable description.
+type Step struct {
+	Description string
+	Cmd         *exec.Cmd
+}
+
+// Cmds returns the commands of the steps.
+func Cmds(steps []Step) []*exec.Cmd {
+	cmds := make([]*exec.Cmd, len(steps))
+	for i, st := range steps {
+		cmds[i] = st.Cmd
+	}
+	return cmds
+}
+
+// WriteScript writes a standalone shell script that runs the steps as root.
+// The "sudo" prefix of the commands that run as root is removed, as the script itself is expected to be run as root.
+// Each line of comment is written as a comment at the head of the script.
+func WriteScript(w io.Writer, comment string, steps []Step) error {
+	var sb strings.Builder
+	sb.WriteString("#!/bin/sh\n")
+	for line := range strings.Lines(strings.TrimSpace(comment)) {
+		sb.WriteString(strings.TrimRight("# "+line, " \n") + "\n")
+	}
+	sb.WriteString(`set -eu
+if [ "$(id -u)" -ne 0 ]; then
+	echo >&2 "This script must be run as root"
+	exit 1
+fi
+`)
+	for i, st := range steps {
+		fmt.Fprintf(&sb, "\n# Step %d/%d: %s\n", i+1, len(steps), st.Description)
+		sb.WriteString(shellescape.QuoteCommand(asRoot(st.Cmd)) + "\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// WriteScriptFile writes the script to the file at path with the executable permission.
+// When path is "-", the script is written to stdout.
+func WriteScriptFile(stdout io.Writer, path, comment string, steps []Step) error {
+	if path == "-" {
+		return WriteScript(stdout, comment, steps)
+	}
+	var sb strings.Builder
+	if err := WriteScript(&sb, comment, steps); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(sb.String()), 0o755); err != nil {
+		return err
+	}
+	// os.WriteFile does not change the permission of an existing file
+	return os.Chmod(path, 0o755)
+}
+
+// asRoot removes the "sudo" prefix (and its flags) of a command that runs as root.
+func asRoot(cmd *exec.Cmd) []string {
+	args := cmd.Args
+	if len(args) == 0 || filepath.Base(args[0]) != "sudo" || RunAs(cmd) != "root" {
+		return args
+	}
+	i := 1
+	for i < len(args) && strings.HasPrefix(args[i], "-") {
+		i++
+	}
+	return args[i:]
+}
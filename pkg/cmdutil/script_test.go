@@ -0,0 +1,55 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdutil
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestWriteScript(t *testing.T) {
+	steps := []Step{
+		{Description: "Create the user", Cmd: exec.Command("sudo", "sysadminctl", "-addUser", "foo")},
+		{Description: "Write the sudoers", Cmd: exec.Command("sudo", "-n", "sh", "-c", "echo 'bar' >/etc/sudoers.d/foo")},
+		{Description: "Run as the user", Cmd: exec.Command("sudo", "-u", "foo", "true")},
+	}
+	var sb strings.Builder
+	assert.NilError(t, WriteScript(&sb, "Create the user foo.\n\nRun as root.", steps))
+	expected := `#!/bin/sh
+# Create the user foo.
+#
+# Run as root.
+set -eu
+if [ "$(id -u)" -ne 0 ]; then
+	echo >&2 "This script must be run as root"
+	exit 1
+fi
+
+# Step 1/3: Create the user
+sysadminctl -addUser foo
+
+# Step 2/3: Write the sudoers
+sh -c 'echo '"'"'bar'"'"' >/etc/sudoers.d/foo'
+
+# Step 3/3: Run as the user
+sudo -u foo true
+`
+	assert.Equal(t, expected, sb.String())
+}
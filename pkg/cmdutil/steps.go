@@ -0,0 +1,255 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"al.essio.dev/pkg/shellescape"
+)
+
+// StepError is returned by [RunSteps] when a step fails.
+type StepError struct {
+	Steps []Step
+	// Completed is the number of the steps that completed successfully.
+	// Steps[Completed] is the failed step.
+	Completed int
+	Err       error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %d/%d (%s) failed after completing %d step(s): %v",
+		e.Completed+1, len(e.Steps), e.Steps[e.Completed].Description, e.Completed, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// elevatePrefix is the command prefix for running the batched steps as root.
+// Overridden in the tests.
+var elevatePrefix = []string{"sudo"}
+
+// RunSteps is similar to [Run], but the consecutive steps that run as root via sudo
+// are batched into a single sudo invocation, so that the password is prompted at most once.
+//
+// The steps are logged one by one, and the execution stops on the first failure
+// with [*StepError] that reports the completed steps.
+func RunSteps(ctx context.Context, steps []Step, opts *RunOpts) error {
+	if opts == nil {
+		opts = &RunOpts{}
+	}
+	cmds := Cmds(steps)
+	if d, ok := ctx.Value(dryRunKey{}).(*dryRun); ok {
+		return d.print(cmds)
+	}
+	if opts.Confirmer != nil {
+		if err := opts.Confirmer.Confirm(ctx, NewPlan(PlanCommands, cmds)); err != nil {
+			return err
+		}
+	}
+	logf := slog.DebugContext
+	if opts.Confirmer != nil && len(steps) > 1 {
+		// Always the progress when running multiple destructive commands
+		logf = slog.InfoContext
+	}
+	var completed int
+	for completed < len(steps) {
+		var (
+			n   int
+			err error
+		)
+		if batch := batchable(steps[completed:]); batch > 0 {
+			n, err = runBatch(ctx, steps[completed:completed+batch], opts, logf)
+		} else {
+			st := steps[completed]
+			logf(ctx, "Running command", "step", st.Description, "cmd", shellescape.QuoteCommand(st.Cmd.Args))
+			if err = Run(ctx, []*exec.Cmd{st.Cmd}, &RunOpts{Stdin: opts.Stdin, Stdout: opts.Stdout, Stderr: opts.Stderr}); err == nil {
+				n = 1
+			}
+		}
+		completed += n
+		if err != nil {
+			return &StepError{Steps: steps, Completed: completed, Err: err}
+		}
+	}
+	return nil
+}
+
+// batchable returns the number of the leading steps that can be batched.
+func batchable(steps []Step) int {
+	for i, st := range steps {
+		args := st.Cmd.Args
+		// Only "sudo CMD ...", without sudo flags
+		if len(args) < 2 || filepath.Base(args[0]) != "sudo" || strings.HasPrefix(args[1], "-") {
+			return i
+		}
+	}
+	return len(steps)
+}
+
+// batchScript returns the shell script that runs the steps, with the progress markers
+// "NONCE begin I" and "NONCE end I STATUS" printed to stdout.
+func batchScript(nonce string, steps []Step) string {
+	var sb strings.Builder
+	sb.WriteString("set -u\n")
+	for i, st := range steps {
+		cmdline := shellescape.QuoteCommand(asRoot(st.Cmd))
+		if st.Cmd.Dir != "" {
+			cmdline = fmt.Sprintf("(cd %s && %s)", shellescape.Quote(st.Cmd.Dir), cmdline)
+		}
+		fmt.Fprintf(&sb, "printf '%s begin %d\\n'\n", nonce, i)
+		fmt.Fprintf(&sb, "%s\n", cmdline)
+		fmt.Fprintf(&sb, "rc=$?\nprintf '%s end %d %%d\\n' \"$rc\"\n[ \"$rc\" -eq 0 ] || exit \"$rc\"\n", nonce, i)
+	}
+	return sb.String()
+}
+
+// runBatch runs the steps in a single elevated shell.
+// The returned int is the number of the completed steps.
+func runBatch(ctx context.Context, steps []Step, opts *RunOpts, logf func(context.Context, string, ...any)) (int, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+	nonce := "alcless-step-" + hex.EncodeToString(b)
+	args := append(append([]string{}, elevatePrefix...), "sh", "-c", batchScript(nonce, steps))
+	c := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stdout io.Writer = io.Discard
+	if opts.Stdout != nil {
+		stdout = opts.Stdout
+	}
+	c.Stderr = os.Stderr
+	if opts.Stderr != nil {
+		c.Stderr = opts.Stderr
+	}
+	if opts.Stdin != nil {
+		c.Stdin = opts.Stdin
+	}
+	var (
+		completed int
+		failed    = -1
+	)
+	w := &markerWriter{w: stdout, nonce: []byte(nonce)}
+	w.onMarker = func(kind string, i, status int) {
+		if i < 0 || i >= len(steps) {
+			return
+		}
+		argsEscaped := shellescape.QuoteCommand(steps[i].Cmd.Args)
+		switch kind {
+		case "begin":
+			logf(ctx, "Running command", "step", steps[i].Description, "cmd", argsEscaped)
+		case "end":
+			if status == 0 {
+				completed = i + 1
+				slog.DebugContext(ctx, "Completed command", "cmd", argsEscaped)
+			} else {
+				failed = i
+			}
+		}
+	}
+	c.Stdout = w
+	slog.DebugContext(ctx, "Running the batched commands", "prefix", elevatePrefix, "steps", len(steps))
+	err := c.Run()
+	w.flush()
+	if err == nil && completed < len(steps) {
+		err = fmt.Errorf("only %d of %d commands reported completion", completed, len(steps))
+	}
+	if err != nil {
+		if failed >= 0 {
+			return completed, fmt.Errorf("failed to run: %v: %w", shellescape.QuoteCommand(steps[failed].Cmd.Args), err)
+		}
+		return completed, fmt.Errorf("failed to run the batched commands with %v: %w", elevatePrefix, err)
+	}
+	return completed, nil
+}
+
+// markerWriter passes through the writes to w, except the marker lines that begin with nonce.
+// The bytes are passed through as soon as they cannot be a part of a marker,
+// so that prompts without a newline are shown immediately.
+type markerWriter struct {
+	w        io.Writer
+	nonce    []byte
+	buf      []byte
+	onMarker func(kind string, i, status int)
+}
+
+func (w *markerWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		if j := bytes.Index(w.buf, w.nonce); j >= 0 {
+			if _, err := w.w.Write(w.buf[:j]); err != nil {
+				return len(p), err
+			}
+			w.buf = w.buf[j:]
+			nl := bytes.IndexByte(w.buf, '\n')
+			if nl < 0 {
+				return len(p), nil
+			}
+			w.parse(string(w.buf[len(w.nonce):nl]))
+			w.buf = w.buf[nl+1:]
+			continue
+		}
+		// Keep the tail that may be the beginning of the nonce
+		keep := 0
+		for k := min(len(w.buf), len(w.nonce)-1); k > 0; k-- {
+			if bytes.HasSuffix(w.buf, w.nonce[:k]) {
+				keep = k
+				break
+			}
+		}
+		_, err := w.w.Write(w.buf[:len(w.buf)-keep])
+		w.buf = w.buf[len(w.buf)-keep:]
+		return len(p), err
+	}
+}
+
+func (w *markerWriter) parse(s string) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return
+	}
+	i, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return
+	}
+	status := 0
+	if len(fields) >= 3 {
+		if status, err = strconv.Atoi(fields[2]); err != nil {
+			return
+		}
+	}
+	w.onMarker(fields[0], i, status)
+}
+
+func (w *markerWriter) flush() {
+	if len(w.buf) > 0 {
+		_, _ = w.w.Write(w.buf)
+		w.buf = nil
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdutil
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestRunSteps(t *testing.T) {
+	elevatePrefix = nil
+	t.Cleanup(func() { elevatePrefix = []string{"sudo"} })
+	ctx := context.Background()
+	steps := []Step{
+		{Description: "foo", Cmd: exec.CommandContext(ctx, "sudo", "echo", "foo")},
+		{Description: "bar", Cmd: exec.CommandContext(ctx, "sudo", "printf", "bar")},
+		{Description: "fail", Cmd: exec.CommandContext(ctx, "sudo", "false")},
+		{Description: "baz", Cmd: exec.CommandContext(ctx, "sudo", "echo", "baz")},
+	}
+	var stdout strings.Builder
+	err := RunSteps(ctx, steps, &RunOpts{Stdout: &stdout})
+	var stepErr *StepError
+	assert.Assert(t, errors.As(err, &stepErr))
+	assert.Equal(t, 2, stepErr.Completed)
+	assert.Equal(t, "fail", stepErr.Steps[stepErr.Completed].Description)
+	assert.Equal(t, "foo\nbar", stdout.String())
+}
+
+func TestMarkerWriter(t *testing.T) {
+	var (
+		sb      strings.Builder
+		markers []string
+	)
+	w := &markerWriter{w: &sb, nonce: []byte("NONCE"), onMarker: func(kind string, i, status int) {
+		markers = append(markers, kind)
+	}}
+	for _, s := range []string{"Password:", " xNON", "CE begin 0\nhello\n", "NONCE end 0 0\nNO"} {
+		_, err := w.Write([]byte(s))
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, "Password: xhello\n", sb.String())
+	w.flush()
+	assert.Equal(t, "Password: xhello\nNO", sb.String())
+	assert.DeepEqual(t, []string{"begin", "end"}, markers)
+}
@@ -0,0 +1,203 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package errdefs defines the errors of the stages of a session, and the exit codes of alclessctl.
+//
+// The exit codes are:
+//
+//	0:     success
+//	1:     generic error
+//	121:   the instance does not exist
+//	122:   syncing the files into the instance failed
+//	123:   syncing the files back was rejected by the user
+//	124:   syncing the files back failed
+//	125:   the command could not be executed
+//	128+N: the command was killed by the signal N
+//	other: the exit code of the command
+//
+// The exit code of the command is overridden by the exit code of the failure of syncing back.
+// The exit code of the command is not overridden by the failure of killing the leftover processes.
+// The original exit code of the command can be obtained by unwrapping the error to [*CommandError].
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	ExitCodeGeneric          = 1
+	ExitCodeInstanceNotFound = 121
+	ExitCodeSyncIn           = 122
+	ExitCodeSyncBackRejected = 123
+	ExitCodeSyncBack         = 124
+	ExitCodeCommandNotRun    = 125
+)
+
+// ExitCoder is implemented by the errors that have an exit code.
+// [*exec.ExitError] implements ExitCoder too.
+type ExitCoder interface {
+	error
+	ExitCode() int
+}
+
+// ExitCode returns the exit code for err.
+// The error chain is unwrapped to find an [ExitCoder].
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ec ExitCoder
+	if errors.As(err, &ec) {
+		if code := ec.ExitCode(); code > 0 {
+			return code
+		}
+	}
+	return ExitCodeGeneric
+}
+
+// InstanceNotFoundError is returned when the instance does not exist.
+type InstanceNotFoundError struct {
+	Instance string
+}
+
+func (e *InstanceNotFoundError) Error() string {
+	return fmt.Sprintf("instance %q does not exist (Hint: run `alclessctl create %s` first)", e.Instance, e.Instance)
+}
+
+func (e *InstanceNotFoundError) ExitCode() int {
+	return ExitCodeInstanceNotFound
+}
+
+// SyncInError is returned when syncing the files into the instance failed.
+type SyncInError struct {
+	Err error
+}
+
+func (e *SyncInError) Error() string {
+	return "failed to sync the files into the instance: " + e.Err.Error()
+}
+
+func (e *SyncInError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SyncInError) ExitCode() int {
+	return ExitCodeSyncIn
+}
+
+// CommandError is returned when the command failed.
+type CommandError struct {
+	Err error
+	// Code is the exit code of the command, or 128+N if the command was killed by the signal N.
+	// Code is [ExitCodeCommandNotRun] if the command could not be executed.
+	Code int
+}
+
+// NewCommandError creates a CommandError from the error returned by running the command.
+func NewCommandError(err error) *CommandError {
+	e := &CommandError{Err: err, Code: ExitCodeCommandNotRun}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			e.Code = 128 + int(ws.Signal())
+		} else if code := exitErr.ExitCode(); code > 0 {
+			e.Code = code
+		}
+	}
+	return e
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CommandError) ExitCode() int {
+	return e.Code
+}
+
+// KillLeftoversError is returned when killing the leftover processes of the command failed.
+// The exit code is [ExitCodeGeneric], not the exit code of ps or kill,
+// so that it is not mistaken for the exit code of the command.
+type KillLeftoversError struct {
+	Err error
+}
+
+func (e *KillLeftoversError) Error() string {
+	return "failed to kill the leftover processes: " + e.Err.Error()
+}
+
+func (e *KillLeftoversError) Unwrap() error {
+	return e.Err
+}
+
+func (e *KillLeftoversError) ExitCode() int {
+	return ExitCodeGeneric
+}
+
+// SyncBackRejectedError is returned when syncing the files back was rejected by the user.
+type SyncBackRejectedError struct {
+	Err error
+	// CommandErr is the error of the command, if any.
+	CommandErr error
+}
+
+func (e *SyncBackRejectedError) Error() string {
+	return "syncing the files back was rejected: " + e.Err.Error()
+}
+
+func (e *SyncBackRejectedError) Unwrap() []error {
+	return nonNil(e.Err, e.CommandErr)
+}
+
+func (e *SyncBackRejectedError) ExitCode() int {
+	return ExitCodeSyncBackRejected
+}
+
+// SyncBackError is returned when syncing the files back failed.
+type SyncBackError struct {
+	Err error
+	// CommandErr is the error of the command, if any.
+	CommandErr error
+}
+
+func (e *SyncBackError) Error() string {
+	return "failed to sync the files back: " + e.Err.Error()
+}
+
+func (e *SyncBackError) Unwrap() []error {
+	return nonNil(e.Err, e.CommandErr)
+}
+
+func (e *SyncBackError) ExitCode() int {
+	return ExitCodeSyncBack
+}
+
+func nonNil(errs ...error) []error {
+	var res []error
+	for _, err := range errs {
+		if err != nil {
+			res = append(res, err)
+		}
+	}
+	return res
+}
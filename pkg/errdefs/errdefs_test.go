@@ -0,0 +1,58 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 0, ExitCode(nil))
+	assert.Equal(t, ExitCodeGeneric, ExitCode(errors.New("foo")))
+	assert.Equal(t, ExitCodeInstanceNotFound, ExitCode(fmt.Errorf("wrapped: %w", &InstanceNotFoundError{Instance: "foo"})))
+	assert.Equal(t, ExitCodeSyncIn, ExitCode(&SyncInError{Err: errors.New("foo")}))
+
+	exitErr := exec.Command("sh", "-c", "exit 42").Run()
+	// Wrapped as in cmdutil.Run
+	wrapped := fmt.Errorf("failed to run: sh: %w", exitErr)
+	assert.Equal(t, 42, ExitCode(wrapped))
+
+	cmdErr := NewCommandError(wrapped)
+	assert.Equal(t, 42, ExitCode(cmdErr))
+	assert.Equal(t, ExitCodeCommandNotRun, ExitCode(NewCommandError(errors.New("not started"))))
+
+	killed := exec.Command("sh", "-c", "kill -KILL $$").Run()
+	assert.Equal(t, 128+9, ExitCode(NewCommandError(killed)))
+
+	syncBackErr := &SyncBackError{Err: errors.New("rsync failed"), CommandErr: cmdErr}
+	assert.Equal(t, ExitCodeSyncBack, ExitCode(syncBackErr))
+	var unwrapped *CommandError
+	assert.Assert(t, errors.As(syncBackErr, &unwrapped))
+	assert.Equal(t, 42, unwrapped.Code)
+
+	assert.Equal(t, ExitCodeSyncBackRejected, ExitCode(&SyncBackRejectedError{Err: errors.New("EOF")}))
+
+	// The exit code of ps or kill is not propagated
+	killErr := &KillLeftoversError{Err: fmt.Errorf("failed to run: ps: %w", exec.Command("sh", "-c", "exit 2").Run())}
+	assert.Equal(t, ExitCodeGeneric, ExitCode(killErr))
+	assert.Equal(t, 42, ExitCode(errors.Join(cmdErr, killErr)))
+}
@@ -0,0 +1,259 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pathpolicy decides whether a host directory may be synced into an instance, or synced back from an instance.
+//
+// A path is denied when it is within a protected path, or when it contains a protected path
+// (e.g., $HOME and "/" contain ~/.ssh).
+// The rules are evaluated in the following order:
+//
+//  1. The user-configured deny entries
+//  2. The user-configured allow entries (the path has to be within an allow entry)
+//  3. The default deny entries ([DefaultDeny] and [DefaultDenySystem])
+//
+// The paths are compared case-insensitively, as the default file system of macOS is case-insensitive.
+package pathpolicy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/AkihiroSuda/alcless/pkg/store/dirnames"
+)
+
+// ConfigFile is the name of the config file in ~/.alcless/_config.
+const ConfigFile = "paths.yaml"
+
+// Config is the user config of the policy.
+// The entries can start with "~/".
+//
+// Example:
+//
+//	allow:
+//	  - ~/Documents/src
+//	deny:
+//	  - ~/secrets
+type Config struct {
+	Allow []string `yaml:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty"`
+}
+
+// ConfigPath returns the path of the config file, e.g., ~/.alcless/_config/paths.yaml.
+func ConfigPath() (string, error) {
+	alclessDir, err := dirnames.AlclessDir()
+	if err != nil {
+		return "", err
+	}
+	// "_config" never conflicts with an instance directory, as an instance name cannot start with "_"
+	return filepath.Join(alclessDir, "_config", ConfigFile), nil
+}
+
+// DefaultDeny returns the default deny entries, relative to the home directory.
+func DefaultDeny() []string {
+	return []string{
+		// Contains the config of Alcoholless itself (paths.yaml), the sessions, and the pool
+		".alcless",
+		"Library",
+		".ssh",
+		".aws",
+		".gnupg",
+		".kube",
+		".docker",
+		// Synced to iCloud Drive when "Desktop & Documents Folders" is enabled
+		"Desktop",
+		"Documents",
+		// Cloud storages that do not use ~/Library/CloudStorage
+		"Dropbox",
+		"Google Drive",
+		"OneDrive",
+		"Box",
+	}
+}
+
+// DefaultDenySystem returns the default deny entries outside the home directory,
+// as syncing back to them would modify the system, the applications, and the programs in $PATH.
+func DefaultDenySystem() []string {
+	return []string{
+		"/bin",
+		"/sbin",
+		"/usr", // including /usr/local
+		"/etc",
+		"/System",
+		"/Library",
+		"/Applications",
+		"/Users/Shared",
+		"/private/etc",
+		"/private/var/db",
+		"/private/var/root",
+		"/var/db",
+		"/var/root",
+		"/var/lib",
+		"/root",
+		"/boot",
+		// Homebrew and MacPorts
+		"/opt/homebrew",
+		"/opt/local",
+		"/home/linuxbrew",
+	}
+}
+
+type Policy struct {
+	allow       []string
+	deny        []string
+	defaultDeny []string
+}
+
+// Load loads the policy for the current user.
+func Load() (*Policy, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	configPath, err := ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	b, err := os.ReadFile(configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err = dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse %q: %w", configPath, err)
+		}
+	}
+	p, err := New(home, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	// $ALCLESS_HOME may differ from ~/.alcless
+	alclessDir, err := dirnames.AlclessDir()
+	if err != nil {
+		return nil, err
+	}
+	p.defaultDeny = append(p.defaultDeny, alclessDir)
+	return p, nil
+}
+
+// New returns the policy for the home directory.
+func New(home string, cfg *Config) (*Policy, error) {
+	if !filepath.IsAbs(home) {
+		return nil, fmt.Errorf("expected an absolute path for the home directory, got %q", home)
+	}
+	p := &Policy{}
+	for _, f := range DefaultDeny() {
+		p.defaultDeny = append(p.defaultDeny, filepath.Join(home, f))
+	}
+	p.defaultDeny = append(p.defaultDeny, DefaultDenySystem()...)
+	var err error
+	if p.allow, err = expand(home, cfg.Allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = expand(home, cfg.Deny); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func expand(home string, entries []string) ([]string, error) {
+	var res []string
+	for _, e := range entries {
+		if e == "~" {
+			e = home
+		} else if rest, ok := strings.CutPrefix(e, "~/"); ok {
+			e = filepath.Join(home, rest)
+		}
+		if !filepath.IsAbs(e) {
+			return nil, fmt.Errorf("expected an absolute path or a path starting with \"~/\", got %q", e)
+		}
+		res = append(res, filepath.Clean(e))
+	}
+	return res, nil
+}
+
+// ProtectedPathError is returned when a path is protected.
+type ProtectedPathError struct {
+	Path string
+	// Protected is the protected path that denied Path.
+	Protected string
+	// Contains is true when Path contains Protected, false when Path is within Protected.
+	Contains bool
+}
+
+func (e *ProtectedPathError) Error() string {
+	if e.Contains {
+		return fmt.Sprintf("%q is protected, as it contains the protected path %q", e.Path, e.Protected)
+	}
+	return fmt.Sprintf("%q is protected, as it is within the protected path %q", e.Path, e.Protected)
+}
+
+// Check returns [*ProtectedPathError] if the path must not be synced.
+// The symbolic links in the path are resolved.
+func (p *Policy) Check(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if err := checkDeny(path, p.deny); err != nil {
+		return err
+	}
+	for _, a := range p.allow {
+		if isWithin(path, a) || isWithin(path, evalSymlinks(a)) {
+			return nil
+		}
+	}
+	return checkDeny(path, p.defaultDeny)
+}
+
+func checkDeny(path string, deny []string) error {
+	for _, d := range deny {
+		for _, dd := range []string{d, evalSymlinks(d)} {
+			if isWithin(path, dd) {
+				return &ProtectedPathError{Path: path, Protected: d}
+			}
+			if isWithin(dd, path) {
+				return &ProtectedPathError{Path: path, Protected: d, Contains: true}
+			}
+		}
+	}
+	return nil
+}
+
+func evalSymlinks(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// isWithin returns true if path is dir or a descendant of dir, case-insensitively.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(strings.ToLower(dir), strings.ToLower(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,118 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package pathpolicy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCheck(t *testing.T) {
+	home := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(home, ".ssh"), 0o700))
+	assert.NilError(t, os.MkdirAll(filepath.Join(home, "src", "foo"), 0o755))
+	assert.NilError(t, os.Symlink(filepath.Join(home, ".ssh"), filepath.Join(home, "src", "ssh-link")))
+	p, err := New(home, &Config{
+		Allow: []string{"~/Documents/src"},
+		Deny:  []string{"~/src/secret"},
+	})
+	assert.NilError(t, err)
+
+	testCases := []struct {
+		path      string
+		protected string // empty if allowed
+		contains  bool
+	}{
+		{"/", "src/secret", true},
+		{home, "src/secret", true},
+		{filepath.Join(home, "src"), "src/secret", true},
+		{filepath.Join(home, "src", "foo"), "", false},
+		{filepath.Join(home, "src", "foo", ".."), "src/secret", true},
+		{filepath.Join(home, "src", "secret", "bar"), "src/secret", false},
+		{filepath.Join(home, ".ssh"), ".ssh", false},
+		{filepath.Join(home, "src", "ssh-link"), ".ssh", false},
+		{filepath.Join(home, "library", "foo"), "Library", false},
+		{filepath.Join(home, "Documents"), "Documents", false},
+		{filepath.Join(home, "Documents", "src", "foo"), "", false},
+		{"/opt/src", "", false},
+		{filepath.Join(home, ".alcless", "_config"), ".alcless", false},
+		{"/usr/local/bin", "/usr", false},
+		{"/opt/homebrew/bin", "/opt/homebrew", false},
+		{"/opt/local", "/opt/local", false},
+		{"/home/linuxbrew/.linuxbrew", "/home/linuxbrew", false},
+		{"/Applications/Foo.app", "/Applications", false},
+		{"/etc", "/etc", false},
+		{"/Users/Shared/foo", "/Users/Shared", false},
+		{"/private/etc/foo", "/private/etc", false},
+		{"/private/var/db/foo", "/private/var/db", false},
+		{"/Library/LaunchDaemons", "/Library", false},
+		{"/System", "/System", false},
+		{"/bin", "/bin", false},
+		{"/sbin", "/sbin", false},
+		{"/var/db", "/var/db", false},
+		{"/var/root", "/var/root", false},
+		{"/private/var/root", "/private/var/root", false},
+		{"/var/lib/foo", "/var/lib", false},
+		{"/root", "/root", false},
+		{"/boot", "/boot", false},
+	}
+	p0, err := New(home, &Config{})
+	assert.NilError(t, err)
+	assert.ErrorContains(t, p0.Check(home), "contains the protected path")
+	assert.NilError(t, p0.Check(filepath.Join(home, "src")))
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			err := p.Check(tc.path)
+			if tc.protected == "" {
+				assert.NilError(t, err)
+				return
+			}
+			var ppe *ProtectedPathError
+			assert.Assert(t, errors.As(err, &ppe), "expected a ProtectedPathError, got %v", err)
+			expected := tc.protected
+			if !filepath.IsAbs(expected) {
+				expected = filepath.Join(home, expected)
+			}
+			// e.g., "/etc" is resolved to "/private/etc" on macOS
+			assert.Equal(t, evalSymlinks(expected), evalSymlinks(ppe.Protected))
+			assert.Equal(t, tc.contains, ppe.Contains)
+		})
+	}
+}
+
+func TestCheckExplicitlyAllowed(t *testing.T) {
+	p, err := New(t.TempDir(), &Config{Allow: []string{"/opt/homebrew/src"}})
+	assert.NilError(t, err)
+	assert.NilError(t, p.Check("/opt/homebrew/src/foo"))
+	assert.ErrorContains(t, p.Check("/opt/homebrew/bin"), "protected")
+}
+
+func TestLoadAlclessHome(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	alclessHome := t.TempDir()
+	t.Setenv("ALCLESS_HOME", alclessHome)
+	p, err := Load()
+	assert.NilError(t, err)
+	var ppe *ProtectedPathError
+	assert.Assert(t, errors.As(p.Check(filepath.Join(alclessHome, "_sessions")), &ppe))
+	assert.Equal(t, alclessHome, ppe.Protected)
+}
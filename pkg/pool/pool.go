@@ -0,0 +1,299 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pool manages the state of the pool of pre-created instances.
+//
+// The state is stored in ~/.alcless/_pool/pool.json, and is updated under a file lock,
+// so that multiple alclessctl processes can lease the instances concurrently.
+// This package does not create nor delete the instances; the callers do.
+package pool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/AkihiroSuda/alcless/pkg/store/dirnames"
+)
+
+const (
+	// StateFile is the name of the state file in the pool directory.
+	StateFile = "pool.json"
+	// LockFile is the name of the lock file in the pool directory.
+	LockFile = "lock"
+	// NamePrefix is the prefix of the names of the instances in the pool.
+	NamePrefix = "pool-"
+)
+
+// ErrNoAvailable is returned by [Lease] when no instance is available.
+var ErrNoAvailable = errors.New("no instance is available in the pool")
+
+type MemberState string
+
+const (
+	// MemberCreating means that the instance is being created.
+	MemberCreating = MemberState("creating")
+	// MemberAvailable means that the instance is ready to be leased.
+	MemberAvailable = MemberState("available")
+	// MemberLeased means that the instance is being used by a session.
+	MemberLeased = MemberState("leased")
+	// MemberDeleting means that the instance is being deleted.
+	MemberDeleting = MemberState("deleting")
+)
+
+type Member struct {
+	Instance string      `json:"instance"`
+	State    MemberState `json:"state"`
+	// Updated is the time when the state was updated.
+	Updated time.Time `json:"updated"`
+	// Recycled is true when the instance was returned without being wiped.
+	Recycled bool `json:"recycled,omitempty"`
+}
+
+type State struct {
+	// Size is the number of the instances to keep available (or being created).
+	Size int `json:"size"`
+	// Template is the template of the instances, e.g., "template://default".
+	Template string    `json:"template,omitempty"`
+	Members  []*Member `json:"members,omitempty"`
+}
+
+// Member returns the member of the instance, or nil.
+func (st *State) Member(instName string) *Member {
+	i := slices.IndexFunc(st.Members, func(m *Member) bool { return m.Instance == instName })
+	if i < 0 {
+		return nil
+	}
+	return st.Members[i]
+}
+
+// Count counts the members in the states.
+func (st *State) Count(states ...MemberState) int {
+	var n int
+	for _, m := range st.Members {
+		if slices.Contains(states, m.State) {
+			n++
+		}
+	}
+	return n
+}
+
+// Remove removes the member of the instance.
+func (st *State) Remove(instName string) {
+	st.Members = slices.DeleteFunc(st.Members, func(m *Member) bool { return m.Instance == instName })
+}
+
+func (m *Member) set(state MemberState) {
+	m.State = state
+	m.Updated = time.Now()
+}
+
+// Dir returns the pool directory, e.g., ~/.alcless/_pool.
+// The underscore prefix avoids collisions with the instance directories.
+func Dir() (string, error) {
+	alclessDir, err := dirnames.AlclessDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(alclessDir, "_pool"), nil
+}
+
+// Load loads the state, without locking.
+func Load() (*State, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	return load(dir)
+}
+
+func load(dir string) (*State, error) {
+	var st State
+	b, err := os.ReadFile(filepath.Join(dir, StateFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &st, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// Update updates the state with fn under the lock.
+// The state is not saved when fn returns an error.
+func Update(fn func(*State) error) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	lockF, err := os.OpenFile(filepath.Join(dir, LockFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer lockF.Close()
+	if err = syscall.Flock(int(lockF.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock %q: %w", lockF.Name(), err)
+	}
+	// The lock is released on closing lockF
+	st, err := load(dir)
+	if err != nil {
+		return err
+	}
+	if err = fn(st); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, StateFile+".tmp")
+	if err = os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, StateFile))
+}
+
+func generateName() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return NamePrefix + hex.EncodeToString(b), nil
+}
+
+// Plan marks the instances to be created or deleted so that Size instances are available or being created.
+// The returned instances have to be created (and then marked as [MemberAvailable] with [SetState]),
+// or deleted (and then removed with [Forget]), by the caller.
+func Plan() (toCreate, toDelete []string, err error) {
+	err = Update(func(st *State) error {
+		toCreate, toDelete = nil, nil
+		n := st.Count(MemberCreating, MemberAvailable)
+		for ; n < st.Size; n++ {
+			name, err := generateName()
+			if err != nil {
+				return err
+			}
+			m := &Member{Instance: name}
+			m.set(MemberCreating)
+			st.Members = append(st.Members, m)
+			toCreate = append(toCreate, name)
+		}
+		// Recycled instances are deleted first
+		for _, recycled := range []bool{true, false} {
+			for _, m := range st.Members {
+				if n <= st.Size {
+					break
+				}
+				if m.State == MemberAvailable && m.Recycled == recycled {
+					m.set(MemberDeleting)
+					toDelete = append(toDelete, m.Instance)
+					n--
+				}
+			}
+		}
+		return nil
+	})
+	return toCreate, toDelete, err
+}
+
+// SetState sets the state of the member.
+func SetState(instName string, state MemberState) error {
+	return Update(func(st *State) error {
+		m := st.Member(instName)
+		if m == nil {
+			return fmt.Errorf("instance %q is not in the pool", instName)
+		}
+		m.set(state)
+		return nil
+	})
+}
+
+// Forget removes the member from the state.
+func Forget(instName string) error {
+	return Update(func(st *State) error {
+		st.Remove(instName)
+		return nil
+	})
+}
+
+// Lease marks an available instance as leased, and returns its name.
+// [ErrNoAvailable] is returned when no instance is available.
+// Pristine instances are preferred to recycled ones.
+func Lease() (string, error) {
+	var instName string
+	err := Update(func(st *State) error {
+		var candidate *Member
+		for _, m := range st.Members {
+			if m.State != MemberAvailable {
+				continue
+			}
+			if candidate == nil || (candidate.Recycled && !m.Recycled) {
+				candidate = m
+			}
+		}
+		if candidate == nil {
+			return ErrNoAvailable
+		}
+		candidate.set(MemberLeased)
+		instName = candidate.Instance
+		return nil
+	})
+	return instName, err
+}
+
+// Return returns the leased instance.
+// When wipe is true, the instance is marked as [MemberDeleting], and has to be deleted by the caller.
+// Otherwise the instance is marked as [MemberAvailable] again, as a recycled instance.
+//
+// cleanup (if not nil) is called under the lock after verifying that the instance is leased,
+// e.g., for killing the processes of the instance before it can be leased again.
+// The state is not changed when cleanup fails.
+func Return(instName string, wipe bool, cleanup func() error) error {
+	return Update(func(st *State) error {
+		m := st.Member(instName)
+		if m == nil {
+			return fmt.Errorf("instance %q is not in the pool", instName)
+		}
+		if m.State != MemberLeased {
+			return fmt.Errorf("instance %q is not leased (state: %q)", instName, m.State)
+		}
+		if cleanup != nil {
+			if err := cleanup(); err != nil {
+				return err
+			}
+		}
+		if wipe {
+			m.set(MemberDeleting)
+		} else {
+			m.Recycled = true
+			m.set(MemberAvailable)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package pool
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestPool(t *testing.T) {
+	t.Setenv("ALCLESS_HOME", t.TempDir())
+	assert.NilError(t, Update(func(st *State) error {
+		st.Size = 2
+		return nil
+	}))
+	toCreate, toDelete, err := Plan()
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(toCreate))
+	assert.Equal(t, 0, len(toDelete))
+	for _, name := range toCreate {
+		assert.Assert(t, strings.HasPrefix(name, NamePrefix))
+	}
+
+	// Being created
+	_, err = Lease()
+	assert.ErrorIs(t, err, ErrNoAvailable)
+	toCreate2, _, err := Plan()
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(toCreate2))
+
+	for _, name := range toCreate {
+		assert.NilError(t, SetState(name, MemberAvailable))
+	}
+	leased, err := Lease()
+	assert.NilError(t, err)
+	assert.Assert(t, leased == toCreate[0] || leased == toCreate[1])
+
+	// Refill while leased
+	toCreate3, _, err := Plan()
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(toCreate3))
+	assert.NilError(t, SetState(toCreate3[0], MemberAvailable))
+
+	// The state is not changed when the cleanup fails
+	assert.ErrorContains(t, Return(leased, false, func() error { return errors.New("cleanup failed") }), "cleanup failed")
+	st, err := Load()
+	assert.NilError(t, err)
+	assert.Equal(t, MemberLeased, st.Member(leased).State)
+
+	// Recycling exceeds the size
+	var cleanedUp int
+	cleanup := func() error {
+		cleanedUp++
+		return nil
+	}
+	assert.NilError(t, Return(leased, false, cleanup))
+	assert.Equal(t, 1, cleanedUp)
+	// The cleanup is not called for an instance that is not leased
+	assert.ErrorContains(t, Return(leased, false, cleanup), "not leased")
+	assert.ErrorContains(t, Return("pool-nonexistent", false, cleanup), "not in the pool")
+	assert.Equal(t, 1, cleanedUp)
+	_, toDelete, err = Plan()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{leased}, toDelete)
+	assert.NilError(t, Forget(toDelete[0]))
+
+	st, err = Load()
+	assert.NilError(t, err)
+	assert.Equal(t, 2, st.Count(MemberAvailable))
+	assert.Equal(t, 0, st.Count(MemberDeleting))
+
+	// Pristine instances are preferred
+	leased, err = Lease()
+	assert.NilError(t, err)
+	assert.Assert(t, !st.Member(leased).Recycled)
+	assert.ErrorContains(t, Return("pool-nonexistent", true, nil), "not in the pool")
+	assert.NilError(t, Return(leased, true, nil))
+	st, err = Load()
+	assert.NilError(t, err)
+	assert.Equal(t, MemberDeleting, st.Member(leased).State)
+}
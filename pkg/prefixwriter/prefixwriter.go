@@ -0,0 +1,71 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package prefixwriter provides a writer that prefixes each line.
+package prefixwriter
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Writer prefixes each line written to the underlying writer.
+// An incomplete line is buffered until a newline is written, or Flush is called.
+//
+// Multiple Writers can share the same underlying writer, as long as they share the same mutex.
+type Writer struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix []byte
+	buf    []byte
+}
+
+func New(w io.Writer, mu *sync.Mutex, prefix string) *Writer {
+	return &Writer{w: w, mu: mu, prefix: []byte(prefix)}
+}
+
+func (pw *Writer) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := pw.writeLine(pw.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the incomplete line, with a newline.
+func (pw *Writer) Flush() error {
+	if len(pw.buf) == 0 {
+		return nil
+	}
+	line := append(pw.buf, '\n')
+	pw.buf = nil
+	return pw.writeLine(line)
+}
+
+func (pw *Writer) writeLine(line []byte) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	_, err := pw.w.Write(append(append([]byte{}, pw.prefix...), line...))
+	return err
+}
@@ -0,0 +1,41 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package prefixwriter
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestWriter(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		mu  sync.Mutex
+	)
+	a := New(&buf, &mu, "[a] ")
+	b := New(&buf, &mu, "[b] ")
+	fmt.Fprint(a, "hello ")
+	fmt.Fprint(b, "foo\nbar\n")
+	fmt.Fprint(a, "world\nincomplete")
+	assert.NilError(t, a.Flush())
+	assert.NilError(t, b.Flush())
+	assert.Equal(t, "[b] foo\n[b] bar\n[a] hello world\n[a] incomplete\n", buf.String())
+}
@@ -0,0 +1,240 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package procutil provides utilities for the processes running as an instance user.
+package procutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+)
+
+type Process struct {
+	PID int `json:"pid"`
+	// CPU is the CPU utilization in percentage.
+	CPU float64 `json:"cpu"`
+	// Memory is the ratio of the resident set size to the physical memory, in percentage.
+	Memory float64 `json:"memory"`
+	// RSS is the resident set size in KiB.
+	RSS     int64     `json:"rss"`
+	Started time.Time `json:"started"`
+	Command string    `json:"command"`
+}
+
+// List lists the processes whose real user is username.
+func List(ctx context.Context, username string) ([]Process, error) {
+	b, err := runPS(ctx, listCmd(ctx, username))
+	if err != nil {
+		return nil, err
+	}
+	return parse(b)
+}
+
+// listCmd returns the ps command for [List].
+// The locale is fixed to C, as the "lstart" field (and the decimal separator) is locale-dependent.
+func listCmd(ctx context.Context, username string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "ps", "-U", username, "-o", "pid=,pcpu=,pmem=,rss=,lstart=,command=")
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	return cmd
+}
+
+// ListSession lists the processes of instUser that belong to the session,
+// i.e., the processes with the environment variable [sudo.SessionEnv] set to the session ID (see [sudo.WithSession]).
+// The environment variables are read with ps executed as instUser.
+func ListSession(ctx context.Context, instUser, session string, o ...sudo.Opt) ([]Process, error) {
+	procs, err := List(ctx, instUser)
+	if err != nil || len(procs) == 0 {
+		return nil, err
+	}
+	psArgs := []string{"-ww", "-U", instUser, "-o", "pid=,command="}
+	if runtime.GOOS == "linux" {
+		psArgs = append(psArgs, "e") // procps
+	} else {
+		psArgs = append([]string{"-E"}, psArgs...)
+	}
+	b, err := runPS(ctx, sudo.Cmd(ctx, instUser, "", "ps", psArgs, o...))
+	if err != nil {
+		return nil, err
+	}
+	pids := parseSessionPIDs(b, session)
+	var res []Process
+	for _, p := range procs {
+		if slices.Contains(pids, p.PID) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// runPS runs the ps command, and returns the stdout.
+func runPS(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	slog.DebugContext(ctx, "Running command", "cmd", cmd.Args)
+	b, err := cmd.Output()
+	if err != nil {
+		// ps exits with 1 when no process matched
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(b) == 0 && stderr.Len() == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to run %v: %w (stderr=%q)", cmd.Args, err, stderr.String())
+	}
+	return b, nil
+}
+
+// parseSessionPIDs parses "PID COMMAND... ENV..." lines, and returns the PIDs with [sudo.SessionEnv]=session.
+func parseSessionPIDs(b []byte, session string) []int {
+	marker := sudo.SessionEnv + "=" + session
+	var res []int
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !slices.Contains(fields[1:], marker) {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[0]); err == nil {
+			res = append(res, pid)
+		}
+	}
+	return res
+}
+
+// lstartLayout is the layout of the "lstart" field of ps, e.g., "Mon Oct 19 10:00:00 2026".
+const lstartLayout = "Mon Jan 2 15:04:05 2006"
+
+func parse(b []byte) ([]Process, error) {
+	var res []Process
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseLine(line)
+		if err != nil {
+			return res, fmt.Errorf("failed to parse ps output line %q: %w", line, err)
+		}
+		res = append(res, p)
+	}
+	return res, scanner.Err()
+}
+
+// parseLine parses "PID PCPU PMEM RSS LSTART(5 fields) COMMAND...".
+func parseLine(line string) (Process, error) {
+	var (
+		p   Process
+		err error
+	)
+	const nFixedFields = 9
+	fields := strings.Fields(line)
+	if len(fields) < nFixedFields+1 {
+		return p, fmt.Errorf("expected at least %d fields, got %d", nFixedFields+1, len(fields))
+	}
+	if p.PID, err = strconv.Atoi(fields[0]); err != nil {
+		return p, err
+	}
+	if p.CPU, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return p, err
+	}
+	if p.Memory, err = strconv.ParseFloat(fields[2], 64); err != nil {
+		return p, err
+	}
+	if p.RSS, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+		return p, err
+	}
+	if p.Started, err = time.ParseInLocation(lstartLayout, strings.Join(fields[4:nFixedFields], " "), time.Local); err != nil {
+		return p, err
+	}
+	// Preserve the spaces in the command line
+	command := line
+	for range nFixedFields {
+		command = strings.TrimSpace(command)
+		_, command, _ = strings.Cut(command, " ")
+	}
+	p.Command = strings.TrimSpace(command)
+	return p, nil
+}
+
+// Subtract returns the processes in procs whose PIDs do not appear in base.
+func Subtract(procs, base []Process) []Process {
+	var res []Process
+	for _, p := range procs {
+		if !slices.ContainsFunc(base, func(b Process) bool { return b.PID == p.PID }) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// KillCmd returns the command that sends the signal (e.g., "TERM") to procs as instUser.
+func KillCmd(ctx context.Context, instUser, signal string, procs []Process, o ...sudo.Opt) *exec.Cmd {
+	args := []string{"-" + signal}
+	for _, p := range procs {
+		args = append(args, strconv.Itoa(p.PID))
+	}
+	return sudo.Cmd(ctx, instUser, "", "kill", args, o...)
+}
+
+// Terminate sends SIGTERM to procs, and sends SIGKILL to the processes that are still
+// running after the timeout.
+// The kill commands are executed as instUser.
+//
+// Terminate returns the processes that are still running after SIGKILL.
+func Terminate(ctx context.Context, instUser string, procs []Process, timeout time.Duration, o ...sudo.Opt) ([]Process, error) {
+	remaining := procs
+	for _, signal := range []string{"TERM", "KILL"} {
+		if len(remaining) == 0 {
+			break
+		}
+		cmd := KillCmd(ctx, instUser, signal, remaining, o...)
+		slog.DebugContext(ctx, "Running command", "cmd", cmd.Args)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			// Some processes may have already exited
+			slog.DebugContext(ctx, "kill failed", "signal", signal, "error", err, "output", string(out))
+		}
+		deadline := time.Now().Add(timeout)
+		for {
+			current, err := List(ctx, instUser)
+			if err != nil {
+				return remaining, err
+			}
+			// Keep the processes that are still running
+			remaining = Subtract(remaining, Subtract(remaining, current))
+			if len(remaining) == 0 || time.Now().After(deadline) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return remaining, ctx.Err()
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+	}
+	return remaining, nil
+}
@@ -0,0 +1,93 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package procutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParse(t *testing.T) {
+	b := []byte(`  123   0.0  0.1   4096 Mon Oct 19 10:00:00 2026     /bin/zsh -l
+ 4567  12.5  3.2 524288 Mon Oct  5 09:08:07 2026     ollama serve
+
+   89   1.0  0.5  65536 Tue Oct 20 23:59:59 2026     /Users/alcless_foo_default/homebrew/bin/node /path/to/language-server  --stdio
+`)
+	procs, err := parse(b)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []Process{
+		{
+			PID:     123,
+			RSS:     4096,
+			Memory:  0.1,
+			Started: time.Date(2026, time.October, 19, 10, 0, 0, 0, time.Local),
+			Command: "/bin/zsh -l",
+		},
+		{
+			PID:     4567,
+			CPU:     12.5,
+			Memory:  3.2,
+			RSS:     524288,
+			Started: time.Date(2026, time.October, 5, 9, 8, 7, 0, time.Local),
+			Command: "ollama serve",
+		},
+		{
+			PID:     89,
+			CPU:     1.0,
+			Memory:  0.5,
+			RSS:     65536,
+			Started: time.Date(2026, time.October, 20, 23, 59, 59, 0, time.Local),
+			Command: "/Users/alcless_foo_default/homebrew/bin/node /path/to/language-server  --stdio",
+		},
+	}, procs)
+
+	_, err = parse([]byte("PID %CPU %MEM RSS STARTED COMMAND\n"))
+	assert.ErrorContains(t, err, "failed to parse")
+
+	// The output in a non-C locale (de_DE.UTF-8) cannot be parsed, so ps has to be executed with LC_ALL=C
+	_, err = parse([]byte("  123   0,0  0,1   4096 Mo 19 Okt 10:00:00 2026     /bin/zsh -l\n"))
+	assert.ErrorContains(t, err, "failed to parse")
+}
+
+func TestListCmd(t *testing.T) {
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	cmd := listCmd(context.Background(), "alcless_foo_default")
+	// The last one wins
+	assert.Equal(t, "LC_ALL=C", cmd.Env[len(cmd.Env)-1])
+}
+
+func TestSubtract(t *testing.T) {
+	procs := []Process{{PID: 1, Command: "a"}, {PID: 2, Command: "b"}, {PID: 3, Command: "c"}}
+	base := []Process{{PID: 2, Command: "b"}}
+	assert.DeepEqual(t, []Process{{PID: 1, Command: "a"}, {PID: 3, Command: "c"}}, Subtract(procs, base))
+	assert.Assert(t, Subtract(base, procs) == nil)
+}
+
+func TestParseSessionPIDs(t *testing.T) {
+	b := []byte(`  123 /bin/zsh -l HOME=/Users/alcless_foo_default ALCLESS_SESSION=s1 SHELL=/bin/zsh
+  124 sleep infinity ALCLESS_SESSION=s1
+  125 sleep infinity ALCLESS_SESSION=s2
+  126 echo ALCLESS_SESSION=s10
+  127 /usr/sbin/cfprefsd agent
+`)
+	assert.DeepEqual(t, []int{123, 124}, parseSessionPIDs(b, "s1"))
+	assert.DeepEqual(t, []int{125}, parseSessionPIDs(b, "s2"))
+	assert.Assert(t, parseSessionPIDs(b, "s3") == nil)
+}
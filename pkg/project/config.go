@@ -0,0 +1,104 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package project
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Config is the per-project config, stored in [MarkerFile] in the project root.
+//
+// Example:
+//
+//	mountRO:
+//	  - ../sibling-repo
+//	  - /opt/sdk:sdk
+type Config struct {
+	// MountRO is the list of "HOSTPATH[:GUESTPATH]" to be synced into the instance one way.
+	// Relative host paths are resolved from the project root.
+	MountRO []string `yaml:"mountRO,omitempty"`
+}
+
+// LoadConfig loads [MarkerFile] in the project root.
+// An empty config is returned if the file does not exist.
+func LoadConfig(root string) (*Config, error) {
+	var cfg Config
+	p := filepath.Join(root, MarkerFile)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err = dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse %q: %w", p, err)
+	}
+	return &cfg, nil
+}
+
+// Mount is a host directory to be synced into the instance one way, and never synced back.
+type Mount struct {
+	HostPath string
+	// GuestPath is relative to the home of the instance user, unless it is absolute.
+	// Empty means the default mapping of the host path.
+	GuestPath string
+}
+
+// ParseMount parses "HOSTPATH[:GUESTPATH]".
+// A relative host path is resolved from baseDir, and "~/" is expanded to the home of the host user.
+func ParseMount(spec, baseDir string) (Mount, error) {
+	hostPath, guestPath, _ := strings.Cut(spec, ":")
+	if hostPath == "" {
+		return Mount{}, fmt.Errorf("invalid mount %q: host path is empty", spec)
+	}
+	if rest, ok := strings.CutPrefix(hostPath, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Mount{}, err
+		}
+		hostPath = filepath.Join(home, rest)
+	}
+	if !filepath.IsAbs(hostPath) {
+		hostPath = filepath.Join(baseDir, hostPath)
+	}
+	hostPath = filepath.Clean(hostPath)
+	if guestPath != "" {
+		guestPath = filepath.Clean(guestPath)
+		if !filepath.IsAbs(guestPath) && !filepath.IsLocal(guestPath) {
+			return Mount{}, fmt.Errorf("invalid mount %q: relative guest path must not contain \"..\"", spec)
+		}
+	}
+	st, err := os.Stat(hostPath)
+	if err != nil {
+		return Mount{}, fmt.Errorf("invalid mount %q: %w", spec, err)
+	}
+	if !st.IsDir() {
+		return Mount{}, fmt.Errorf("invalid mount %q: %q is not a directory", spec, hostPath)
+	}
+	return Mount{HostPath: hostPath, GuestPath: guestPath}, nil
+}
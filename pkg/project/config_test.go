@@ -0,0 +1,62 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	root := t.TempDir()
+	cfg, err := LoadConfig(root)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(cfg.MountRO))
+
+	assert.NilError(t, os.WriteFile(filepath.Join(root, MarkerFile), []byte("mountRO:\n  - ../sibling\n  - /opt/sdk:sdk\n"), 0o644))
+	cfg, err = LoadConfig(root)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"../sibling", "/opt/sdk:sdk"}, cfg.MountRO)
+
+	assert.NilError(t, os.WriteFile(filepath.Join(root, MarkerFile), []byte("unknown: true\n"), 0o644))
+	_, err = LoadConfig(root)
+	assert.ErrorContains(t, err, "unknown")
+}
+
+func TestParseMount(t *testing.T) {
+	base := t.TempDir()
+	assert.NilError(t, os.Mkdir(filepath.Join(base, "sdk"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(base, "file"), nil, 0o644))
+
+	m, err := ParseMount("sdk", base)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, Mount{HostPath: filepath.Join(base, "sdk")}, m)
+
+	m, err = ParseMount(filepath.Join(base, "sdk")+":work/sdk", "/")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, Mount{HostPath: filepath.Join(base, "sdk"), GuestPath: "work/sdk"}, m)
+
+	_, err = ParseMount("sdk:../sdk", base)
+	assert.ErrorContains(t, err, "must not contain")
+	_, err = ParseMount("file", base)
+	assert.ErrorContains(t, err, "not a directory")
+	_, err = ParseMount("nonexistent", base)
+	assert.ErrorContains(t, err, "no such file")
+}
@@ -0,0 +1,51 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package project detects the project root of the host working directory.
+package project
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// MarkerFile is the file that marks the project root.
+const MarkerFile = ".alcless.yaml"
+
+// FindRoot returns the nearest ancestor of dir (including dir itself) that contains [MarkerFile] or ".git".
+// ".git" can be a directory, or a file (for submodules and worktrees), like `git rev-parse --show-toplevel`.
+// An empty string is returned when no project root is found.
+func FindRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range []string{MarkerFile, ".git"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return dir, nil
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return "", err
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
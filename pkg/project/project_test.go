@@ -0,0 +1,48 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestFindRoot(t *testing.T) {
+	tmp := t.TempDir()
+	repo := filepath.Join(tmp, "repo")
+	sub := filepath.Join(repo, "pkg", "foo")
+	assert.NilError(t, os.MkdirAll(sub, 0o755))
+	assert.NilError(t, os.Mkdir(filepath.Join(repo, ".git"), 0o755))
+
+	root, err := FindRoot(sub)
+	assert.NilError(t, err)
+	assert.Equal(t, repo, root)
+
+	// The nearest marker wins
+	marked := filepath.Join(repo, "pkg")
+	assert.NilError(t, os.WriteFile(filepath.Join(marked, MarkerFile), nil, 0o644))
+	root, err = FindRoot(sub)
+	assert.NilError(t, err)
+	assert.Equal(t, marked, root)
+
+	root, err = FindRoot(tmp)
+	assert.NilError(t, err)
+	assert.Equal(t, "", root)
+}
@@ -0,0 +1,205 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package recorder records the terminal I/O of a command in the asciicast v2 format.
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"al.essio.dev/pkg/shellescape"
+	"github.com/creack/pty"
+	"golang.org/x/term"
+
+	"github.com/AkihiroSuda/alcless/pkg/asciicast"
+)
+
+// MetadataSuffix is appended to the path of a recording to store the [Metadata].
+const MetadataSuffix = ".json"
+
+// Metadata is the metadata of a recorded session.
+type Metadata struct {
+	Instance string    `json:"instance"`
+	Command  []string  `json:"command"`
+	HostWD   string    `json:"hostWD"`
+	GuestWD  string    `json:"guestWD"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	ExitCode int       `json:"exitCode"`
+	// SyncBack is the decision of syncing back the files, e.g., "applied".
+	SyncBack string `json:"syncBack,omitempty"`
+}
+
+func SaveMetadata(castPath string, md *Metadata) error {
+	b, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(castPath+MetadataSuffix, append(b, '\n'), 0o600)
+}
+
+func LoadMetadata(castPath string) (*Metadata, error) {
+	b, err := os.ReadFile(castPath + MetadataSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var md Metadata
+	if err = json.Unmarshal(b, &md); err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+type Opts struct {
+	Stdin  *os.File
+	Stdout *os.File
+	Stderr *os.File
+	// RecordInput records the input too.
+	// Disabled by default, as the input may contain secrets.
+	RecordInput bool
+}
+
+// Run runs cmd, and records the terminal I/O to w.
+//
+// When the stdin is a terminal, cmd is executed in a new pseudo terminal.
+// Otherwise cmd is executed with pipes, and the stdout and the stderr are recorded as the output.
+func Run(ctx context.Context, cmd *exec.Cmd, w io.Writer, opts Opts) error {
+	hdr := asciicast.Header{
+		Width:   80,
+		Height:  24,
+		Command: shellescape.QuoteCommand(cmd.Args),
+		Env:     map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	}
+	if width, height, err := term.GetSize(int(opts.Stdout.Fd())); err == nil && width > 0 && height > 0 {
+		hdr.Width, hdr.Height = width, height
+	}
+	cw, err := asciicast.NewWriter(w, hdr)
+	if err != nil {
+		return err
+	}
+	if !term.IsTerminal(int(opts.Stdin.Fd())) {
+		slog.DebugContext(ctx, "stdin is not a terminal, recording without a pseudo terminal")
+		return runWithoutPTY(cmd, cw, opts)
+	}
+	return runWithPTY(ctx, cmd, cw, opts)
+}
+
+func runWithoutPTY(cmd *exec.Cmd, cw *asciicast.Writer, opts Opts) error {
+	output := cw.EventWriter(asciicast.EventTypeOutput)
+	eventWriters := []io.Closer{output}
+	cmd.Stdin = opts.Stdin
+	if opts.RecordInput {
+		input := cw.EventWriter(asciicast.EventTypeInput)
+		eventWriters = append(eventWriters, input)
+		cmd.Stdin = io.TeeReader(opts.Stdin, input)
+	}
+	cmd.Stdout = io.MultiWriter(opts.Stdout, output)
+	cmd.Stderr = io.MultiWriter(opts.Stderr, output)
+	err := cmd.Run()
+	// Flush the incomplete UTF-8 sequences
+	for _, ew := range eventWriters {
+		if closeErr := ew.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func runWithPTY(ctx context.Context, cmd *exec.Cmd, cw *asciicast.Writer, opts Opts) error {
+	stdinFd := int(opts.Stdin.Fd())
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return err
+	}
+	defer ptmx.Close()
+
+	sigwinch := make(chan os.Signal, 1)
+	signal.Notify(sigwinch, syscall.SIGWINCH)
+	defer func() {
+		signal.Stop(sigwinch)
+		// Stops the goroutine. No signal is sent after signal.Stop returns.
+		close(sigwinch)
+	}()
+	go func() {
+		for range sigwinch {
+			if err := pty.InheritSize(opts.Stdin, ptmx); err != nil {
+				slog.DebugContext(ctx, "failed to resize the pseudo terminal", "error", err)
+			}
+		}
+	}()
+	sigwinch <- syscall.SIGWINCH
+
+	oldState, err := term.MakeRaw(stdinFd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(stdinFd, oldState)
+
+	// The stdin is read in the non-blocking mode, so that the reader goroutine can be stopped
+	// when the command exits. Otherwise the goroutine would steal the input for the subsequent
+	// confirmation prompt.
+	// The fd is duplicated so that closing the *os.File does not close the original stdin.
+	dupFd, err := syscall.Dup(stdinFd)
+	if err != nil {
+		return err
+	}
+	if err = syscall.SetNonblock(dupFd, true); err != nil {
+		syscall.Close(dupFd)
+		return err
+	}
+	// The non-blocking flag is shared with the original stdin, so it has to be restored
+	defer syscall.SetNonblock(stdinFd, false)
+	stdin := os.NewFile(uintptr(dupFd), opts.Stdin.Name())
+	defer stdin.Close()
+	stdinDone := make(chan struct{})
+	go func() {
+		defer close(stdinDone)
+		var r io.Reader = stdin
+		if opts.RecordInput {
+			input := cw.EventWriter(asciicast.EventTypeInput)
+			defer input.Close()
+			r = io.TeeReader(stdin, input)
+		}
+		if _, err := io.Copy(ptmx, r); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			slog.DebugContext(ctx, "failed to copy the stdin", "error", err)
+		}
+	}()
+
+	// io.Copy fails with EIO on Linux when the command exits
+	output := cw.EventWriter(asciicast.EventTypeOutput)
+	if _, err = io.Copy(io.MultiWriter(opts.Stdout, output), ptmx); err != nil && !errors.Is(err, syscall.EIO) {
+		slog.DebugContext(ctx, "failed to copy the output", "error", err)
+	}
+	if err = output.Close(); err != nil {
+		slog.DebugContext(ctx, "failed to write the output", "error", err)
+	}
+	waitErr := cmd.Wait()
+	if err = stdin.SetReadDeadline(time.Now()); err != nil {
+		slog.DebugContext(ctx, "failed to set the read deadline of the stdin", "error", err)
+	} else {
+		<-stdinDone
+	}
+	return waitErr
+}
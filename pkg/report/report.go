@@ -0,0 +1,133 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package report provides the machine-readable result of a session (`alclessctl shell --result-json`).
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/AkihiroSuda/alcless/pkg/rsync"
+)
+
+// Result is the result of a session.
+type Result struct {
+	Instance string   `json:"instance"`
+	HostWD   string   `json:"hostWD"`
+	GuestWD  string   `json:"guestWD"`
+	Command  []string `json:"command"`
+	// ExitCode is the exit code of the command itself.
+	// Nil if the command was not executed, or was killed by a signal.
+	ExitCode *int `json:"exitCode,omitempty"`
+	// AlclessctlExitCode is the exit code of alclessctl, which differs from ExitCode
+	// when another stage failed (See [github.com/AkihiroSuda/alcless/pkg/errdefs]).
+	// As the exit codes of alclessctl overlap with the exit codes of the command (e.g., 124 for syncing back),
+	// ExitCode has to be checked to distinguish them.
+	AlclessctlExitCode int `json:"alclessctlExitCode"`
+	// Signal is the name of the signal that killed the command.
+	Signal    string    `json:"signal,omitempty"`
+	Durations Durations `json:"durations"`
+	SyncIn    SyncIn    `json:"syncIn"`
+	SyncBack  SyncBack  `json:"syncBack"`
+	Warnings  []string  `json:"warnings,omitempty"`
+	// Error is the error that aborted the session.
+	Error string `json:"error,omitempty"`
+}
+
+// Durations are in seconds.
+type Durations struct {
+	SyncIn   float64 `json:"syncIn"`
+	Run      float64 `json:"run"`
+	SyncBack float64 `json:"syncBack"`
+}
+
+type SyncIn struct {
+	Changes rsync.Changes `json:"changes"`
+}
+
+type SyncBack struct {
+	// Decision is "skipped", "nothing", "applied", "rejected", or "failed".
+	Decision string `json:"decision"`
+	// Changes are the applied changes, or the planned changes if the decision is not "applied".
+	Changes rsync.Changes `json:"changes"`
+}
+
+// Write writes the result to the file.
+func (r *Result) Write(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// WarningCollector is a [slog.Handler] that collects the warnings and the errors,
+// and passes all the records to the underlying handler.
+type WarningCollector struct {
+	slog.Handler
+	mu       *sync.Mutex
+	warnings *[]string
+	attrs    []slog.Attr
+}
+
+func NewWarningCollector(h slog.Handler) *WarningCollector {
+	return &WarningCollector{Handler: h, mu: &sync.Mutex{}, warnings: &[]string{}}
+}
+
+func (c *WarningCollector) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn {
+		var sb strings.Builder
+		sb.WriteString(r.Message)
+		appendAttr := func(a slog.Attr) bool {
+			fmt.Fprintf(&sb, " %s=%v", a.Key, a.Value)
+			return true
+		}
+		for _, a := range c.attrs {
+			appendAttr(a)
+		}
+		r.Attrs(appendAttr)
+		c.mu.Lock()
+		*c.warnings = append(*c.warnings, sb.String())
+		c.mu.Unlock()
+	}
+	return c.Handler.Handle(ctx, r)
+}
+
+func (c *WarningCollector) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c2 := *c
+	c2.Handler = c.Handler.WithAttrs(attrs)
+	c2.attrs = append(append([]slog.Attr{}, c.attrs...), attrs...)
+	return &c2
+}
+
+func (c *WarningCollector) WithGroup(name string) slog.Handler {
+	c2 := *c
+	c2.Handler = c.Handler.WithGroup(name)
+	return &c2
+}
+
+// Warnings returns the collected warnings.
+func (c *WarningCollector) Warnings() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), *c.warnings...)
+}
@@ -0,0 +1,64 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestWarningCollector(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewWarningCollector(slog.NewTextHandler(&buf, nil))
+	logger := slog.New(c)
+	logger.Info("info", "k", "v")
+	logger.With("instance", "default").Warn("warn", "pid", 42)
+	logger.Error("error")
+	assert.DeepEqual(t, []string{"warn instance=default pid=42", "error"}, c.Warnings())
+	// All the records are passed to the underlying handler
+	assert.Equal(t, 3, bytes.Count(buf.Bytes(), []byte("\n")))
+}
+
+func TestResultWrite(t *testing.T) {
+	// The command exited with 124, and syncing back failed with 124 too
+	exitCode := 124
+	r := &Result{Instance: "default", ExitCode: &exitCode, AlclessctlExitCode: 124}
+	f := filepath.Join(t.TempDir(), "result.json")
+	assert.NilError(t, r.Write(f))
+	b, err := os.ReadFile(f)
+	assert.NilError(t, err)
+	var m map[string]any
+	assert.NilError(t, json.Unmarshal(b, &m))
+	assert.Equal(t, float64(124), m["exitCode"])
+	assert.Equal(t, float64(124), m["alclessctlExitCode"])
+
+	// The exit code of alclessctl is not omitted on success
+	exitCode = 0
+	r.AlclessctlExitCode = 0
+	assert.NilError(t, r.Write(f))
+	b, err = os.ReadFile(f)
+	assert.NilError(t, err)
+	m = nil
+	assert.NilError(t, json.Unmarshal(b, &m))
+	assert.Equal(t, float64(0), m["alclessctlExitCode"])
+}
@@ -0,0 +1,79 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package rsync
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// Changes are the changes parsed from the output of `rsync --itemize-changes`.
+type Changes struct {
+	Created  []string `json:"created"`
+	Modified []string `json:"modified"`
+	Deleted  []string `json:"deleted"`
+}
+
+// ParseItemized parses the output of `rsync --itemize-changes`, e.g.,
+//
+//	*deleting SOME_FILE
+//	.d..t.... ./
+//	>f+++++++ SOME_FILE.xz
+//
+// The width of the itemized code varies across the implementations of rsync.
+// Attribute-only changes of directories are ignored.
+func ParseItemized(b []byte) Changes {
+	var changes Changes
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		code, name, ok := strings.Cut(line, " ")
+		if !ok || len(code) < 2 {
+			continue
+		}
+		if code[0] == '*' {
+			// "*deleting" is padded with spaces
+			if code == "*deleting" {
+				changes.Deleted = append(changes.Deleted, strings.TrimLeft(name, " "))
+			}
+			continue
+		}
+		if code[1] == 'L' {
+			// "LINK -> TARGET"
+			name, _, _ = strings.Cut(name, " -> ")
+		}
+		if strings.Trim(code[2:], "+") == "" {
+			changes.Created = append(changes.Created, name)
+			continue
+		}
+		switch code[0] {
+		case '<', '>', 'c', 'h':
+			changes.Modified = append(changes.Modified, name)
+		case '.':
+			if code[1] != 'd' {
+				changes.Modified = append(changes.Modified, name)
+			}
+		}
+	}
+	return changes
+}
+
+// Empty returns true if there is no change.
+func (c *Changes) Empty() bool {
+	return len(c.Created) == 0 && len(c.Modified) == 0 && len(c.Deleted) == 0
+}
@@ -0,0 +1,52 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package rsync
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseItemized(t *testing.T) {
+	// rsync 2.6.9 and openrsync
+	changes := ParseItemized([]byte(`*deleting SOME_FILE
+.d..t.... ./
+>f+++++++ SOME_FILE.xz
+`))
+	assert.DeepEqual(t, Changes{
+		Created: []string{"SOME_FILE.xz"},
+		Deleted: []string{"SOME_FILE"},
+	}, changes)
+
+	// rsync 3.x
+	changes = ParseItemized([]byte(`*deleting   old dir/file with spaces
+cd+++++++++ new/
+>f+++++++++ new/foo
+>f.st...... modified
+.f...p..... chmod
+cL+++++++++ link -> target
+.d..t...... ./
+`))
+	assert.DeepEqual(t, Changes{
+		Created:  []string{"new/", "new/foo", "link"},
+		Modified: []string{"modified", "chmod"},
+		Deleted:  []string{"old dir/file with spaces"},
+	}, changes)
+	assert.Assert(t, !changes.Empty())
+	assert.Assert(t, (&Changes{}).Empty())
+}
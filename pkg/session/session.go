@@ -0,0 +1,277 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package session manages the detached sessions (`alclessctl shell --detach`).
+//
+// The state of a session is stored in ~/.alcless/_sessions/<SESSION>:
+//   - session.json: the metadata ([Session])
+//   - output.log:   the stdout and the stderr of the command
+//   - exitcode:     the exit code of the command, written on exit
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/v2/pkg/identifiers"
+
+	"github.com/AkihiroSuda/alcless/pkg/store/dirnames"
+)
+
+const (
+	MetadataFile = "session.json"
+	LogFile      = "output.log"
+	ExitCodeFile = "exitcode"
+)
+
+// Session is the metadata of a detached session.
+type Session struct {
+	Name     string    `json:"name"`
+	Instance string    `json:"instance"`
+	User     string    `json:"user"`
+	Command  []string  `json:"command"`
+	HostWD   string    `json:"hostWD"`
+	GuestWD  string    `json:"guestWD"`
+	Plain    bool      `json:"plain,omitempty"`
+	ReadOnly bool      `json:"readOnly,omitempty"`
+	PID      int       `json:"pid"`
+	Created  time.Time `json:"created"`
+	// FreshDir is the per-session directory (`alclessctl shell --fresh`) to be removed on finishing the session.
+	FreshDir string `json:"freshDir,omitempty"`
+	// KillLeftovers is set when the leftover processes are to be killed on finishing the session.
+	KillLeftovers bool `json:"killLeftovers,omitempty"`
+	// SessionID is the value of $ALCLESS_SESSION that marks the processes of the session to be killed
+	// (See [github.com/AkihiroSuda/alcless/pkg/sudo.WithSession]).
+	SessionID string `json:"sessionID,omitempty"`
+}
+
+type Status string
+
+const (
+	StatusRunning = Status("Running")
+	StatusExited  = Status("Exited")
+	// StatusUnknown is the status of a session whose process is no longer alive
+	// but did not record the exit code.
+	StatusUnknown = Status("Unknown")
+)
+
+func sessionsDir() (string, error) {
+	alclessDir, err := dirnames.AlclessDir()
+	if err != nil {
+		return "", err
+	}
+	// "_sessions" never conflicts with an instance directory, as an instance name cannot start with "_"
+	return filepath.Join(alclessDir, "_sessions"), nil
+}
+
+// Dir returns the directory of the session.
+func Dir(name string) (string, error) {
+	if err := identifiers.Validate(name); err != nil {
+		return "", err
+	}
+	dir, err := sessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// DefaultName returns the default name of a new session of the instance.
+func DefaultName(instName string) string {
+	return instName + "-" + time.Now().Format("20060102-150405")
+}
+
+// Start starts cmd in the background as a new session.
+// The stdin of cmd is connected to /dev/null, and the stdout and the stderr are written to the log file.
+// The PID and the creation time of s are filled in.
+func Start(ctx context.Context, s *Session, cmd *exec.Cmd) error {
+	dir, err := Dir(s.Name)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(dir), 0o700); err != nil {
+		return err
+	}
+	if err = os.Mkdir(dir, 0o700); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("session %q already exists", s.Name)
+		}
+		return err
+	}
+	// The exit code is written by the host-side shell, as alclessctl itself exits immediately.
+	const script = `"$@" </dev/null >>"$0/` + LogFile + `" 2>&1; echo "$?" >"$0/` + ExitCodeFile + `.tmp" && mv "$0/` + ExitCodeFile + `.tmp" "$0/` + ExitCodeFile + `"`
+	// Not bound to ctx, as the session outlives alclessctl
+	wrapped := exec.Command("/bin/sh", append([]string{"-c", script, dir}, cmd.Args...)...)
+	// Detach from the terminal, so that the session is not killed on closing the terminal
+	wrapped.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	slog.DebugContext(ctx, "Starting a session", "session", s.Name, "cmd", wrapped.Args)
+	if err = wrapped.Start(); err != nil {
+		return err
+	}
+	s.PID = wrapped.Process.Pid
+	s.Created = time.Now()
+	if err = wrapped.Process.Release(); err != nil {
+		return err
+	}
+	return save(dir, s)
+}
+
+func save(dir string, s *Session) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, MetadataFile), append(b, '\n'), 0o600)
+}
+
+// Load loads the metadata of the session.
+func Load(name string) (*Session, error) {
+	dir, err := Dir(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, MetadataFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("session %q does not exist", name)
+		}
+		return nil, err
+	}
+	var s Session
+	if err = json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// List lists the sessions.
+func List() ([]Session, error) {
+	dir, err := sessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var res []Session
+	for _, ent := range ents {
+		if !ent.IsDir() {
+			continue
+		}
+		s, err := Load(ent.Name())
+		if err != nil {
+			return res, err
+		}
+		res = append(res, *s)
+	}
+	return res, nil
+}
+
+// Remove removes the directory of the session.
+func Remove(name string) error {
+	dir, err := Dir(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// Status returns the status of the session.
+// The exit code is returned only when the status is [StatusExited].
+func (s *Session) Status() (Status, int, error) {
+	dir, err := Dir(s.Name)
+	if err != nil {
+		return StatusUnknown, 0, err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, ExitCodeFile))
+	if err == nil {
+		exitCode, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return StatusUnknown, 0, fmt.Errorf("failed to parse the exit code of session %q: %w", s.Name, err)
+		}
+		return StatusExited, exitCode, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return StatusUnknown, 0, err
+	}
+	if err = syscall.Kill(s.PID, 0); err == nil || errors.Is(err, syscall.EPERM) {
+		return StatusRunning, 0, nil
+	}
+	return StatusUnknown, 0, nil
+}
+
+// Follow copies the log of the session to w.
+// When follow is true, Follow keeps copying until the session stops running, or ctx is cancelled.
+func (s *Session) Follow(ctx context.Context, w io.Writer, follow bool) error {
+	dir, err := Dir(s.Name)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filepath.Join(dir, LogFile))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		// The log file is created by the shell asynchronously
+		if !follow {
+			return nil
+		}
+	} else {
+		defer f.Close()
+	}
+	for {
+		// Check the status before copying, so that the output written just before exiting is not lost
+		st, _, err := s.Status()
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			if f, err = os.Open(filepath.Join(dir, LogFile)); err == nil {
+				defer f.Close()
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if f != nil {
+			if _, err = io.Copy(w, f); err != nil {
+				return err
+			}
+		}
+		if !follow || st != StatusRunning {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
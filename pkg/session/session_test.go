@@ -0,0 +1,76 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+func TestSession(t *testing.T) {
+	t.Setenv("ALCLESS_HOME", t.TempDir())
+	ctx := context.Background()
+	s := &Session{Name: "foo", Instance: "default"}
+	cmd := exec.CommandContext(ctx, "sh", "-c", "echo hello; echo world >&2; exit 42")
+	assert.NilError(t, Start(ctx, s, cmd))
+	assert.Assert(t, s.PID != 0)
+	assert.ErrorContains(t, Start(ctx, &Session{Name: "foo"}, cmd), "already exists")
+
+	var out bytes.Buffer
+	assert.NilError(t, s.Follow(ctx, &out, true))
+	assert.Equal(t, "hello\nworld\n", out.String())
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		st, _, err := s.Status()
+		if err != nil {
+			return poll.Error(err)
+		}
+		if st != StatusExited {
+			return poll.Continue("status is %q", st)
+		}
+		return poll.Success()
+	}, poll.WithTimeout(10*time.Second))
+	_, exitCode, err := s.Status()
+	assert.NilError(t, err)
+	assert.Equal(t, 42, exitCode)
+
+	sessions, err := List()
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(sessions))
+	assert.Equal(t, s.PID, sessions[0].PID)
+
+	assert.NilError(t, Remove("foo"))
+	_, err = Load("foo")
+	assert.ErrorContains(t, err, "does not exist")
+}
+
+func TestSessionKillLeftovers(t *testing.T) {
+	t.Setenv("ALCLESS_HOME", t.TempDir())
+	ctx := context.Background()
+	s := &Session{Name: "foo", Instance: "default", KillLeftovers: true, SessionID: "0123456789abcdef"}
+	assert.NilError(t, Start(ctx, s, exec.CommandContext(ctx, "true")))
+
+	loaded, err := Load("foo")
+	assert.NilError(t, err)
+	assert.Assert(t, loaded.KillLeftovers)
+	assert.Equal(t, "0123456789abcdef", loaded.SessionID)
+}
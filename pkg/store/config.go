@@ -0,0 +1,131 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AkihiroSuda/alcless/pkg/store/dirnames"
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+	"github.com/AkihiroSuda/alcless/pkg/workdir"
+)
+
+// ConfigFile is the name of the per-instance config file in the instance directory.
+const ConfigFile = "config.json"
+
+// Config is the per-instance config, stored in ~/.alcless/<INSTANCE>/config.json.
+type Config struct {
+	// Limits are the default resource limits for the sessions.
+	Limits sudo.Limits `json:"limits,omitzero"`
+	// Record enables recording the sessions into ~/.alcless/<INSTANCE>/recordings.
+	Record bool `json:"record,omitempty"`
+	// WorkdirMapping is the default policy of mapping the host working directory to the guest working directory.
+	WorkdirMapping workdir.Policy `json:"workdirMapping,omitempty"`
+	// Sudoers is the sudoers policy of the instance.
+	Sudoers sudo.Policy `json:"sudoers,omitzero"`
+	// Backend is the name of the privilege-switching backend (See [sudo.BackendNames]).
+	// Empty means "sudo-su".
+	Backend string `json:"backend,omitempty"`
+}
+
+// SudoBackend returns the privilege-switching backend of the instance.
+func (c *Config) SudoBackend() (sudo.Backend, error) {
+	return sudo.BackendByName(c.Backend)
+}
+
+// SudoOpts returns the options of [sudo.Cmd] for the instance.
+// An unknown backend falls back to the default one with a warning, as the backend is validated in [LoadConfig];
+// sudo rejects the command if the backend does not match the installed sudoers file.
+func (c *Config) SudoOpts() []sudo.Opt {
+	b, err := c.SudoBackend()
+	if err != nil {
+		slog.Warn("Falling back to the default backend", "error", err)
+		b, _ = sudo.BackendByName("")
+	}
+	return []sudo.Opt{sudo.WithPolicy(&c.Sudoers), sudo.WithBackend(b)}
+}
+
+// LoadConfig loads the config of the instance.
+// An empty config is returned if the config file does not exist.
+func LoadConfig(instName string) (*Config, error) {
+	instDir, err := dirnames.InstanceDir(instName)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	b, err := os.ReadFile(filepath.Join(instDir, ConfigFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	if _, err = sudo.BackendByName(cfg.Backend); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// SaveConfig saves the config of the instance.
+func SaveConfig(instName string, cfg *Config) error {
+	instDir, err := dirnames.InstanceDir(instName)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(instDir, 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(instDir, ConfigFile), append(b, '\n'), 0o600)
+}
+
+// NewRecordingPath returns the path of a new session recording of the instance.
+// The file name has a random suffix, so that the concurrent sessions do not overwrite the recordings of each other.
+func NewRecordingPath(instName string) (string, error) {
+	instDir, err := dirnames.InstanceDir(instName)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 4)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+	name := time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b) + ".cast"
+	return filepath.Join(instDir, "recordings", name), nil
+}
+
+// RemoveInstanceDir removes the host-side directory of the instance.
+func RemoveInstanceDir(instName string) error {
+	instDir, err := dirnames.InstanceDir(instName)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(instDir)
+}
@@ -0,0 +1,49 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package dirnames provides the host-side directory names of Alcoholless.
+package dirnames
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// AlclessDir returns the value of $ALCLESS_HOME, or ~/.alcless if $ALCLESS_HOME is unset.
+func AlclessDir() (string, error) {
+	if dir := os.Getenv("ALCLESS_HOME"); dir != "" {
+		return dir, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if homeDir == "" {
+		return "", errors.New("cannot determine the home directory")
+	}
+	return filepath.Join(homeDir, ".alcless"), nil
+}
+
+// InstanceDir returns the host-side directory of the instance, e.g., ~/.alcless/default.
+// The directory is not created by this function.
+func InstanceDir(instName string) (string, error) {
+	alclessDir, err := AlclessDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(alclessDir, instName), nil
+}
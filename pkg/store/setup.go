@@ -0,0 +1,91 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AkihiroSuda/alcless/pkg/store/dirnames"
+)
+
+// SetupFile is the name of the file in the instance directory that records an incomplete setup.
+const SetupFile = "setup.json"
+
+// SetupState is the state of an incomplete setup, stored in ~/.alcless/<INSTANCE>/setup.json.
+// The file is removed when the setup is completed.
+type SetupState struct {
+	// Completed is the number of the completed setup steps.
+	Completed int `json:"completed"`
+	// Failed is the description of the failed step.
+	Failed string `json:"failed"`
+	// Error is the error message of the failed step.
+	Error   string    `json:"error,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// LoadSetupState loads the state of the incomplete setup of the instance.
+// Nil is returned if the setup is not incomplete.
+func LoadSetupState(instName string) (*SetupState, error) {
+	instDir, err := dirnames.InstanceDir(instName)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(instDir, SetupFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var st SetupState
+	if err = json.Unmarshal(b, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// SaveSetupState records the instance as incomplete.
+func SaveSetupState(instName string, st *SetupState) error {
+	instDir, err := dirnames.InstanceDir(instName)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(instDir, 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(instDir, SetupFile), append(b, '\n'), 0o600)
+}
+
+// RemoveSetupState records the instance as complete.
+func RemoveSetupState(instName string) error {
+	instDir, err := dirnames.InstanceDir(instName)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(filepath.Join(instDir, SetupFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
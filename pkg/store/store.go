@@ -23,12 +23,19 @@ import (
 
 	"github.com/containerd/containerd/v2/pkg/identifiers"
 
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
 	"github.com/AkihiroSuda/alcless/pkg/userutil"
 )
 
 type Instance struct {
 	Name string `json:"name"`
 	User string `json:"user"`
+	// Setup is set when the setup of the instance is incomplete.
+	Setup *SetupState `json:"setup,omitempty"`
+	// Sudoers is the sudoers policy of the instance.
+	Sudoers sudo.Policy `json:"sudoers,omitzero"`
+	// Backend is the name of the privilege-switching backend.
+	Backend string `json:"backend"`
 }
 
 func Instances(ctx context.Context) ([]Instance, error) {
@@ -45,7 +52,19 @@ func Instances(ctx context.Context) ([]Instance, error) {
 		if err = ValidateName(instName); err != nil {
 			return res, err
 		}
-		res = append(res, Instance{Name: instName, User: u})
+		setup, err := LoadSetupState(instName)
+		if err != nil {
+			return res, err
+		}
+		cfg, err := LoadConfig(instName)
+		if err != nil {
+			return res, err
+		}
+		backend, err := cfg.SudoBackend()
+		if err != nil {
+			return res, err
+		}
+		res = append(res, Instance{Name: instName, User: u, Setup: setup, Sudoers: cfg.Sudoers, Backend: backend.Name()})
 	}
 	return res, nil
 }
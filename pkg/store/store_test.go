@@ -0,0 +1,84 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+	"github.com/AkihiroSuda/alcless/pkg/userutil"
+)
+
+func TestInstances(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("ALCLESS_HOME", t.TempDir())
+	fake := userutil.NewFake(t.TempDir())
+	orig := userutil.Default
+	userutil.Default = fake
+	t.Cleanup(func() { userutil.Default = orig })
+
+	fake.Add("root")
+	fake.Add(userutil.UserFromInstance("foo"))
+	fake.Add(userutil.UserFromInstance("bar"))
+	assert.NilError(t, SaveConfig("bar", &Config{Sudoers: sudo.Policy{Allow: []string{"/usr/bin/make"}}, Backend: "runuser"}))
+	assert.NilError(t, SaveSetupState("bar", &SetupState{Completed: 3, Failed: "Install Homebrew", Updated: time.Now()}))
+
+	insts, err := Instances(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(insts))
+	// Sorted by the user name
+	bar, foo := insts[0], insts[1]
+	assert.Equal(t, "bar", bar.Name)
+	assert.Equal(t, "runuser", bar.Backend)
+	assert.Equal(t, "restricted (make)", bar.Sudoers.String())
+	assert.Equal(t, "Install Homebrew", bar.Setup.Failed)
+	assert.Equal(t, "foo", foo.Name)
+	assert.Equal(t, "sudo-su", foo.Backend)
+	assert.Assert(t, foo.Setup == nil)
+
+	assert.NilError(t, RemoveSetupState("bar"))
+	setup, err := LoadSetupState("bar")
+	assert.NilError(t, err)
+	assert.Assert(t, setup == nil)
+}
+
+func TestNewRecordingPath(t *testing.T) {
+	t.Setenv("ALCLESS_HOME", t.TempDir())
+	p1, err := NewRecordingPath("default")
+	assert.NilError(t, err)
+	p2, err := NewRecordingPath("default")
+	assert.NilError(t, err)
+	// Unique even within the same second
+	assert.Assert(t, p1 != p2)
+}
+
+func TestConfigSudoBackend(t *testing.T) {
+	cfg := &Config{Backend: "foo"}
+	_, err := cfg.SudoBackend()
+	assert.ErrorContains(t, err, "unknown backend")
+	// Falls back to the default backend, without panicking
+	assert.Equal(t, 2, len(cfg.SudoOpts()))
+
+	t.Setenv("ALCLESS_HOME", t.TempDir())
+	assert.NilError(t, SaveConfig("foo", cfg))
+	_, err = LoadConfig("foo")
+	assert.ErrorContains(t, err, "unknown backend")
+}
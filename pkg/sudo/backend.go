@@ -0,0 +1,148 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sudo
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// UIDPlaceholder is replaced with the UID of the instance user when the sudoers file is installed,
+// as the user may not exist yet when the sudoers file is rendered.
+const UIDPlaceholder = "@ALCLESS_UID@"
+
+// Backend switches the user to run a shell snippet as the instance user.
+// The switching command itself is executed as root via `sudo -n`, as allowed by [Backend.SudoersCommand].
+type Backend interface {
+	// Name returns the name of the backend, e.g., "sudo-su".
+	Name() string
+	// Args returns the arguments to be passed to `sudo -n` for running the snippet as instUser.
+	// uid is the UID of instUser.
+	Args(instUser, uid, snippet string) []string
+	// SudoersCommand returns the command specification in the sudoers.
+	// uid may be [UIDPlaceholder].
+	SudoersCommand(instUser, uid string) string
+}
+
+// sudoSu runs `su - USER -c SNIPPET`, so as to create a launchd session.
+// This is the default backend.
+type sudoSu struct{}
+
+func (sudoSu) Name() string {
+	return "sudo-su"
+}
+
+func (sudoSu) Args(instUser, _, snippet string) []string {
+	return []string{"/usr/bin/su", "-", instUser, "-c", snippet}
+}
+
+func (sudoSu) SudoersCommand(instUser, _ string) string {
+	return fmt.Sprintf("/usr/bin/su - %s -c *", instUser)
+}
+
+// launchctlAsuser runs `su` in the launchd bootstrap namespace of the instance user (macOS only).
+type launchctlAsuser struct{}
+
+func (launchctlAsuser) Name() string {
+	return "launchctl-asuser"
+}
+
+func (launchctlAsuser) Args(instUser, uid, snippet string) []string {
+	return []string{"/bin/launchctl", "asuser", uid, "/usr/bin/su", "-", instUser, "-c", snippet}
+}
+
+func (launchctlAsuser) SudoersCommand(instUser, uid string) string {
+	return fmt.Sprintf("/bin/launchctl asuser %s /usr/bin/su - %s -c *", uid, instUser)
+}
+
+// runuser runs `runuser -l USER -c SNIPPET` (Linux only).
+type runuser struct{}
+
+func (runuser) Name() string {
+	return "runuser"
+}
+
+func (runuser) Args(instUser, _, snippet string) []string {
+	return []string{"/usr/sbin/runuser", "-l", instUser, "-c", snippet}
+}
+
+func (runuser) SudoersCommand(instUser, _ string) string {
+	return fmt.Sprintf("/usr/sbin/runuser -l %s -c *", instUser)
+}
+
+var backends = []Backend{sudoSu{}, launchctlAsuser{}, runuser{}}
+
+// BackendNames returns the names of the available backends.
+func BackendNames() []string {
+	res := make([]string, len(backends))
+	for i, b := range backends {
+		res[i] = b.Name()
+	}
+	return res
+}
+
+// BackendByName returns the backend with the name.
+// Empty name means "sudo-su", for the instances created before the backend was recorded.
+func BackendByName(name string) (Backend, error) {
+	if name == "" {
+		return sudoSu{}, nil
+	}
+	for _, b := range backends {
+		if b.Name() == name {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown backend %q (expected one of %v)", name, BackendNames())
+}
+
+// DefaultBackend returns the default backend for new instances on the current platform.
+func DefaultBackend() Backend {
+	if runtime.GOOS == "linux" {
+		return runuser{}
+	}
+	return sudoSu{}
+}
+
+// WithBackend specifies the backend. The default is "sudo-su".
+func WithBackend(b Backend) Opt {
+	return func(o *opts) {
+		o.backend = b
+	}
+}
+
+// lookupUID returns the UID of instUser, or [UIDPlaceholder] if the user does not exist.
+func lookupUID(instUser string) string {
+	u, err := user.Lookup(instUser)
+	if err != nil {
+		return UIDPlaceholder
+	}
+	return u.Uid
+}
+
+func backendCmd(ctx context.Context, b Backend, instUser, snippet string) *exec.Cmd {
+	var uid string
+	// Avoid looking up the user unless needed
+	if strings.Contains(b.SudoersCommand(instUser, UIDPlaceholder), UIDPlaceholder) {
+		uid = lookupUID(instUser)
+	}
+	return exec.CommandContext(ctx, "sudo", slices.Concat([]string{"-n"}, b.Args(instUser, uid, snippet))...)
+}
@@ -0,0 +1,105 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sudo
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ulimitOptions maps the names of the resource limits to the options of the `ulimit` shell builtin.
+var ulimitOptions = map[string]string{
+	"nofile": "-n", // number of open files
+	"nproc":  "-u", // number of processes of the user
+	"cpu":    "-t", // CPU time in seconds
+	"fsize":  "-f", // file size in 512-byte blocks
+}
+
+// UlimitNames returns the names of the supported resource limits.
+func UlimitNames() []string {
+	return slices.Sorted(maps.Keys(ulimitOptions))
+}
+
+// Limits are the resource limits and the scheduling priority of a session.
+type Limits struct {
+	// Ulimits maps a name in UlimitNames to the value.
+	// Both the soft and the hard limits are set to the value.
+	Ulimits map[string]uint64 `json:"ulimits,omitempty"`
+	// Nice is the niceness (0-20).
+	Nice int `json:"nice,omitempty"`
+	// Background runs the command with the background QoS (macOS only).
+	Background bool `json:"background,omitempty"`
+}
+
+// ParseUlimit parses "NAME=VALUE", e.g., "nofile=1024".
+func ParseUlimit(s string) (string, uint64, error) {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return "", 0, fmt.Errorf("expected NAME=VALUE, got %q", s)
+	}
+	if _, ok = ulimitOptions[k]; !ok {
+		return "", 0, fmt.Errorf("unknown ulimit %q (expected one of %v)", k, UlimitNames())
+	}
+	value, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to parse the value of ulimit %q: %w", k, err)
+	}
+	return k, value, nil
+}
+
+func (l *Limits) Validate() error {
+	for k := range l.Ulimits {
+		if _, ok := ulimitOptions[k]; !ok {
+			return fmt.Errorf("unknown ulimit %q (expected one of %v)", k, UlimitNames())
+		}
+	}
+	// Negative values are not allowed, as the instance user cannot raise the priority.
+	if l.Nice < 0 || l.Nice > 20 {
+		return fmt.Errorf("expected nice to be in the range of 0-20, got %d", l.Nice)
+	}
+	return nil
+}
+
+// IsZero returns true if no limit is set.
+func (l *Limits) IsZero() bool {
+	return len(l.Ulimits) == 0 && l.Nice == 0 && !l.Background
+}
+
+// snippetPrefix returns the shell snippet to be executed before `cd`.
+func (l *Limits) snippetPrefix() string {
+	var sb strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(l.Ulimits)) {
+		// -H -S sets both the hard and the soft limits, so that the limits cannot be raised again
+		fmt.Fprintf(&sb, "ulimit -H -S %s %d || exit 1 ; ", ulimitOptions[k], l.Ulimits[k])
+	}
+	return sb.String()
+}
+
+// execPrefix returns the commands to be prepended to the command executed with `exec`.
+func (l *Limits) execPrefix() []string {
+	var res []string
+	if l.Nice != 0 {
+		res = append(res, "nice", "-n", strconv.Itoa(l.Nice))
+	}
+	if l.Background {
+		res = append(res, "taskpolicy", "-b")
+	}
+	return res
+}
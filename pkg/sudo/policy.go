@@ -0,0 +1,133 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sudo
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FreshDirParent is the parent of the per-session directories of `alclessctl shell --fresh`,
+// relative to the home of the instance user.
+const FreshDirParent = ".alcless-fresh"
+
+// BaseCommands are the commands always allowed in the restricted mode, in the sudoers syntax,
+// as they are executed by alclessctl itself (e.g., for syncing the files and for killing the leftover processes).
+// ps is executed as the instance user, as the environment variables of the processes of another user are not visible.
+//
+// The arguments are narrowed to the forms used by alclessctl, e.g., rsync is only allowed in the server mode,
+// as `rsync -e COMMAND` runs an arbitrary command.
+// Note that a wildcard in the arguments matches spaces too, so the narrowing is not strict for mkdir and rm.
+var BaseCommands = []string{
+	"/usr/bin/rsync --server *",
+	"/bin/mkdir -p -m 700 /*",
+	"/bin/rm -rf /*/" + FreshDirParent + "/*",
+	"/bin/kill",
+	"/bin/ps",
+	`/usr/bin/true ""`,
+}
+
+// Policy is the sudoers policy of an instance.
+type Policy struct {
+	// Allow is the allowlist of the absolute paths of the programs that can be executed as the instance user.
+	// Empty means the unrestricted mode, in which `su - INSTUSER -c *` is allowed.
+	// [BaseCommands] are implicitly allowed in the restricted mode.
+	Allow []string `json:"allow,omitempty"`
+}
+
+// Restricted returns true if the policy is the restricted mode.
+func (p *Policy) Restricted() bool {
+	return p != nil && len(p.Allow) > 0
+}
+
+// Programs returns the paths of the allowed programs, including the programs of [BaseCommands].
+// Nil is returned in the unrestricted mode.
+func (p *Policy) Programs() []string {
+	if !p.Restricted() {
+		return nil
+	}
+	var res []string
+	for _, f := range BaseCommands {
+		res = append(res, strings.Fields(f)[0])
+	}
+	for _, f := range p.Allow {
+		if !slices.Contains(res, f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// Commands returns the allowed commands in the sudoers syntax, including [BaseCommands].
+// The programs in the allowlist can be executed with any arguments.
+// Nil is returned in the unrestricted mode.
+func (p *Policy) Commands() []string {
+	if !p.Restricted() {
+		return nil
+	}
+	res := slices.Clone(BaseCommands)
+	for _, f := range p.Allow {
+		if !slices.Contains(res, f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// String returns a short human-readable representation, e.g., "restricted (brew, claude)".
+func (p *Policy) String() string {
+	if !p.Restricted() {
+		return "unrestricted"
+	}
+	names := make([]string, len(p.Allow))
+	for i, f := range p.Allow {
+		names[i] = filepath.Base(f)
+	}
+	return "restricted (" + strings.Join(names, ", ") + ")"
+}
+
+func (p *Policy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, f := range p.Allow {
+		if !filepath.IsAbs(f) || filepath.Clean(f) != f {
+			return fmt.Errorf("expected an absolute and clean path of a program, got %q", f)
+		}
+		// Avoid the wildcards and the special characters of sudoers
+		if strings.ContainsAny(f, "*?[]!,:=\\\"'#() \t\n") {
+			return fmt.Errorf("path of a program must not contain special characters, got %q", f)
+		}
+	}
+	return nil
+}
+
+// resolve resolves the command name to the absolute path of an allowed program with the same base name.
+// The command name is returned as is if no program is matched, and sudo will reject it.
+func (p *Policy) resolve(cmdExe string) string {
+	if filepath.IsAbs(cmdExe) {
+		return cmdExe
+	}
+	for _, f := range p.Programs() {
+		if filepath.Base(f) == cmdExe {
+			return f
+		}
+	}
+	return cmdExe
+}
@@ -18,6 +18,8 @@
 //
 // su is wrapped inside sudo, so as to create a launchd session, which is necessary to isolate `open(1)`.
 // sudo cannot create a session because `/etc/pam.d/sudo` lacks the config for `pam_launchd.so`.
+//
+// The wrapper of su can be replaced with a [Backend].
 package sudo
 
 import (
@@ -26,6 +28,7 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"al.essio.dev/pkg/shellescape"
@@ -35,23 +38,106 @@ func SudoersPath(instUser string) (string, error) {
 	return filepath.Join("/etc/sudoers.d/", instUser), nil
 }
 
-func Sudoers(instUser string) (string, error) {
+// Sudoers returns the sudoers rule of instUser.
+// In the restricted mode, the programs in the allowlist can be executed directly as instUser
+// in any working directory, regardless of the backend.
+// uid may be [UIDPlaceholder].
+func Sudoers(instUser, uid string, policy *Policy, backend Backend) (string, error) {
 	currentUser, err := user.Current()
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%s ALL=(root) NOPASSWD: /usr/bin/su - %s -c *", currentUser.Username, instUser), nil
+	if policy.Restricted() {
+		if err = policy.Validate(); err != nil {
+			return "", err
+		}
+		// The session marker is passed on the command line of sudo (see [WithSession])
+		return fmt.Sprintf("Defaults>%s env_keep += %q\n%s ALL=(%s) CWD=* NOPASSWD: %s",
+			instUser, SessionEnv, currentUser.Username, instUser, strings.Join(policy.Commands(), ", ")), nil
+	}
+	return fmt.Sprintf("%s ALL=(root) NOPASSWD: %s", currentUser.Username, backend.SudoersCommand(instUser, uid)), nil
+}
+
+type opts struct {
+	limits  *Limits
+	policy  *Policy
+	backend Backend
+	session string
+}
+
+type Opt func(o *opts)
+
+// WithLimits applies the resource limits and the scheduling priority.
+// The limits must be validated in advance with [Limits.Validate].
+func WithLimits(limits *Limits) Opt {
+	return func(o *opts) {
+		o.limits = limits
+	}
+}
+
+// WithPolicy applies the sudoers policy of the instance.
+// In the restricted mode, the command is executed with `sudo -u` instead of `su`,
+// so the limits are not applied, and neither the launchd session nor the login environment is created.
+// The command name is resolved to the absolute path in the allowlist.
+func WithPolicy(policy *Policy) Opt {
+	return func(o *opts) {
+		o.policy = policy
+	}
+}
+
+// SessionEnv is the environment variable that marks the processes of a session (see [WithSession]).
+const SessionEnv = "ALCLESS_SESSION"
+
+// WithSession sets [SessionEnv] to the session ID, so that the processes of the session can be
+// distinguished from the processes of the other sessions of the same instance user.
+// The variable is inherited by the descendant processes unless they clear the environment.
+func WithSession(session string) Opt {
+	return func(o *opts) {
+		o.session = session
+	}
 }
 
-func Cmd(ctx context.Context, instUser, wd, cmdExe string, cmdArgs []string) *exec.Cmd {
+func Cmd(ctx context.Context, instUser, wd, cmdExe string, cmdArgs []string, o ...Opt) *exec.Cmd {
+	var opts opts
+	for _, f := range o {
+		f(&opts)
+	}
+	if opts.policy.Restricted() {
+		args := []string{"-n", "-u", instUser, "-H"}
+		if wd != "" {
+			args = append(args, "-D", wd)
+		}
+		args = append(args, "--")
+		if opts.session != "" {
+			args = append(args, SessionEnv+"="+opts.session)
+		}
+		args = append(args, opts.policy.resolve(cmdExe))
+		return exec.CommandContext(ctx, "sudo", append(args, cmdArgs...)...)
+	}
+	var snippetPrefix string
+	if opts.session != "" {
+		// The login shell of su does not inherit the environment
+		snippetPrefix = fmt.Sprintf("export %s=%s ; ", SessionEnv, shellescape.Quote(opts.session))
+	}
+	if opts.limits != nil {
+		snippetPrefix += opts.limits.snippetPrefix()
+		if execPrefix := opts.limits.execPrefix(); len(execPrefix) > 0 {
+			cmdArgs = slices.Concat(execPrefix[1:], []string{cmdExe}, cmdArgs)
+			cmdExe = execPrefix[0]
+		}
+	}
 	quotedArgs := make([]string, len(cmdArgs))
 	for i, f := range cmdArgs {
 		quotedArgs[i] = shellescape.Quote(f)
 	}
-	snippet := fmt.Sprintf("cd %s ; exec %s %s", // cd may fail
+	snippet := fmt.Sprintf("%scd %s ; exec %s %s", // cd may fail
+		snippetPrefix,
 		shellescape.Quote(wd), // can be empty
 		shellescape.Quote(cmdExe),
 		strings.Join(quotedArgs, " "))
-	cmd := exec.CommandContext(ctx, "sudo", "-n", "/usr/bin/su", "-", instUser, "-c", snippet)
-	return cmd
+	backend := opts.backend
+	if backend == nil {
+		backend = sudoSu{}
+	}
+	return backendCmd(ctx, backend, instUser, snippet)
 }
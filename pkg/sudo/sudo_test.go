@@ -0,0 +1,129 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sudo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCmd(t *testing.T) {
+	ctx := context.Background()
+	cmd := Cmd(ctx, "alcless_foo_default", "/tmp/wd", "echo", []string{"hello", "world's"})
+	assert.DeepEqual(t, []string{"sudo", "-n", "/usr/bin/su", "-", "alcless_foo_default", "-c",
+		`cd /tmp/wd ; exec echo hello 'world'"'"'s'`}, cmd.Args)
+
+	limits := &Limits{
+		Ulimits:    map[string]uint64{"nproc": 256, "nofile": 1024},
+		Nice:       10,
+		Background: true,
+	}
+	assert.NilError(t, limits.Validate())
+	cmd = Cmd(ctx, "alcless_foo_default", "", "make", []string{"-j4"}, WithLimits(limits))
+	assert.DeepEqual(t, []string{"sudo", "-n", "/usr/bin/su", "-", "alcless_foo_default", "-c",
+		`ulimit -H -S -n 1024 || exit 1 ; ulimit -H -S -u 256 || exit 1 ; cd '' ; exec nice -n 10 taskpolicy -b make -j4`}, cmd.Args)
+
+	cmd = Cmd(ctx, "alcless_foo_default", "", "make", nil, WithLimits(limits), WithSession("s1"))
+	assert.DeepEqual(t, []string{"sudo", "-n", "/usr/bin/su", "-", "alcless_foo_default", "-c",
+		`export ALCLESS_SESSION=s1 ; ulimit -H -S -n 1024 || exit 1 ; ulimit -H -S -u 256 || exit 1 ; cd '' ; exec nice -n 10 taskpolicy -b make`}, cmd.Args)
+}
+
+func TestParseUlimit(t *testing.T) {
+	k, v, err := ParseUlimit("nofile=1024")
+	assert.NilError(t, err)
+	assert.Equal(t, "nofile", k)
+	assert.Equal(t, uint64(1024), v)
+
+	_, _, err = ParseUlimit("nofile")
+	assert.ErrorContains(t, err, "expected NAME=VALUE")
+	_, _, err = ParseUlimit("foo=1")
+	assert.ErrorContains(t, err, "unknown ulimit")
+	_, _, err = ParseUlimit("cpu=-1")
+	assert.ErrorContains(t, err, "failed to parse")
+
+	assert.ErrorContains(t, (&Limits{Nice: -1}).Validate(), "range")
+}
+
+func TestCheckSudoers(t *testing.T) {
+	expected := []byte("foo ALL=(root) NOPASSWD: /usr/bin/su - bar -c *\n")
+	f := filepath.Join(t.TempDir(), "bar")
+	assert.NilError(t, os.WriteFile(f, expected, 0o440))
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	assert.NilError(t, checkSudoers(f, expected, uid, gid))
+
+	assert.NilError(t, os.Chmod(f, 0o644))
+	assert.ErrorContains(t, checkSudoers(f, expected, uid, gid), "mode 644 (expected 440)")
+
+	assert.NilError(t, os.Chmod(f, 0o440))
+	err := checkSudoers(f, []byte("foo ALL=(ALL) NOPASSWD: ALL\n"), uid, gid)
+	assert.ErrorIs(t, err, ErrSudoersDrift)
+	assert.ErrorContains(t, err, "unexpected content")
+}
+
+func TestCmdRestricted(t *testing.T) {
+	ctx := context.Background()
+	policy := &Policy{Allow: []string{"/opt/homebrew/bin/brew"}}
+	assert.NilError(t, policy.Validate())
+	cmd := Cmd(ctx, "alcless_foo_default", "/tmp/wd", "brew", []string{"install", "xz"}, WithPolicy(policy))
+	assert.DeepEqual(t, []string{"sudo", "-n", "-u", "alcless_foo_default", "-H", "-D", "/tmp/wd", "--",
+		"/opt/homebrew/bin/brew", "install", "xz"}, cmd.Args)
+	cmd = Cmd(ctx, "alcless_foo_default", "", "rsync", []string{"--server"}, WithPolicy(policy))
+	assert.DeepEqual(t, []string{"sudo", "-n", "-u", "alcless_foo_default", "-H", "--",
+		"/usr/bin/rsync", "--server"}, cmd.Args)
+
+	rule, err := Sudoers("alcless_foo_default", "501", policy, sudoSu{})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasSuffix(rule, ` ALL=(alcless_foo_default) CWD=* NOPASSWD: /usr/bin/rsync --server *, /bin/mkdir -p -m 700 /*, /bin/rm -rf /*/.alcless-fresh/*, /bin/kill, /bin/ps, /usr/bin/true "", /opt/homebrew/bin/brew`), rule)
+	assert.Assert(t, strings.HasPrefix(rule, `Defaults>alcless_foo_default env_keep += "ALCLESS_SESSION"`+"\n"), rule)
+
+	cmd = Cmd(ctx, "alcless_foo_default", "", "brew", []string{"list"}, WithPolicy(policy), WithSession("s1"))
+	assert.DeepEqual(t, []string{"sudo", "-n", "-u", "alcless_foo_default", "-H", "--",
+		"ALCLESS_SESSION=s1", "/opt/homebrew/bin/brew", "list"}, cmd.Args)
+	// rsync with arbitrary arguments would allow running an arbitrary command with `rsync -e`
+	for _, c := range strings.Split(strings.SplitN(rule, "NOPASSWD: ", 2)[1], ", ") {
+		if strings.HasPrefix(c, "/usr/bin/rsync") {
+			assert.Equal(t, "/usr/bin/rsync --server *", c)
+		}
+	}
+	assert.Equal(t, "restricted (brew)", policy.String())
+
+	assert.ErrorContains(t, (&Policy{Allow: []string{"brew"}}).Validate(), "absolute")
+	assert.ErrorContains(t, (&Policy{Allow: []string{"/bin/*"}}).Validate(), "special characters")
+}
+
+func TestBackend(t *testing.T) {
+	ctx := context.Background()
+	b, err := BackendByName("runuser")
+	assert.NilError(t, err)
+	cmd := Cmd(ctx, "alcless_foo_default", "/tmp/wd", "echo", []string{"hello"}, WithBackend(b))
+	assert.DeepEqual(t, []string{"sudo", "-n", "/usr/sbin/runuser", "-l", "alcless_foo_default", "-c",
+		`cd /tmp/wd ; exec echo hello`}, cmd.Args)
+
+	b, err = BackendByName("launchctl-asuser")
+	assert.NilError(t, err)
+	rule, err := Sudoers("alcless_foo_default", UIDPlaceholder, nil, b)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasSuffix(rule, " ALL=(root) NOPASSWD: /bin/launchctl asuser @ALCLESS_UID@ /usr/bin/su - alcless_foo_default -c *"), rule)
+
+	_, err = BackendByName("foo")
+	assert.ErrorContains(t, err, "unknown backend")
+}
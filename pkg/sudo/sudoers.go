@@ -0,0 +1,161 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sudo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"al.essio.dev/pkg/shellescape"
+)
+
+// SudoersMode is the permission of the installed sudoers file.
+const SudoersMode = fs.FileMode(0o440)
+
+// RenderSudoers renders the content of the sudoers file of instUser.
+// uid may be [UIDPlaceholder].
+func RenderSudoers(instUser, uid string, policy *Policy, backend Backend) ([]byte, error) {
+	rule, err := Sudoers(instUser, uid, policy, backend)
+	if err != nil {
+		return nil, err
+	}
+	return []byte("# Generated by alclessctl. Do not edit.\n" + rule + "\n"), nil
+}
+
+// InstallSudoersCmd returns the command that validates and installs the sudoers file of instUser.
+//
+// The file is written to a temporary file in /etc/sudoers.d, which is ignored by sudo as the name contains '.',
+// validated with `visudo -c`, and atomically renamed with the mode 0440 and the owner root:wheel
+// (gid 0 is "wheel" on macOS).
+//
+// [UIDPlaceholder] is replaced with the UID of instUser on installation.
+func InstallSudoersCmd(ctx context.Context, instUser string, policy *Policy, backend Backend) (*exec.Cmd, error) {
+	content, err := RenderSudoers(instUser, UIDPlaceholder, policy, backend)
+	if err != nil {
+		return nil, err
+	}
+	sudoersPath, err := SudoersPath(instUser)
+	if err != nil {
+		return nil, err
+	}
+	tmpTemplate := filepath.Join(filepath.Dir(sudoersPath), "."+filepath.Base(sudoersPath)+".XXXXXX")
+	script := fmt.Sprintf(`set -eu
+%stmp=$(mktemp %s)
+trap 'rm -f "$tmp"' EXIT
+%s >"$tmp"
+chown 0:0 "$tmp"
+chmod %o "$tmp"
+visudo -c -f "$tmp"
+mv -f "$tmp" %s`,
+		uidSnippet(instUser, content), shellescape.Quote(tmpTemplate), printSnippet(content), SudoersMode, shellescape.Quote(sudoersPath))
+	return exec.CommandContext(ctx, "sudo", "sh", "-c", script), nil
+}
+
+// VerifySudoersCmd returns the command that verifies the content of the installed sudoers file,
+// as well as the mode, the owner, and the syntax.
+// The command has to be executed as root, as the sudoers file is not readable by the current user.
+func VerifySudoersCmd(ctx context.Context, instUser string, policy *Policy, backend Backend) (*exec.Cmd, error) {
+	content, err := RenderSudoers(instUser, UIDPlaceholder, policy, backend)
+	if err != nil {
+		return nil, err
+	}
+	sudoersPath, err := SudoersPath(instUser)
+	if err != nil {
+		return nil, err
+	}
+	f := shellescape.Quote(sudoersPath)
+	script := fmt.Sprintf(`set -eu
+%s%s | cmp -s - %s || { echo >&2 "unexpected content: "%s; exit 1; }
+visudo -c -f %s`, uidSnippet(instUser, content), printSnippet(content), f, f, f)
+	return exec.CommandContext(ctx, "sudo", "sh", "-c", script), nil
+}
+
+// uidSnippet returns the shell snippet that sets $uid, if the content contains [UIDPlaceholder].
+func uidSnippet(instUser string, content []byte) string {
+	if !bytes.Contains(content, []byte(UIDPlaceholder)) {
+		return ""
+	}
+	return fmt.Sprintf("uid=$(id -u %s)\n", shellescape.Quote(instUser))
+}
+
+// printSnippet returns the shell snippet that prints the content, with [UIDPlaceholder] replaced with $uid.
+func printSnippet(content []byte) string {
+	snippet := "printf '%s' " + shellescape.Quote(string(content))
+	if bytes.Contains(content, []byte(UIDPlaceholder)) {
+		snippet += fmt.Sprintf(` | sed -e "s/%s/$uid/g"`, UIDPlaceholder)
+	}
+	return snippet
+}
+
+// ErrSudoersDrift is returned when the installed sudoers file does not match the expected one.
+var ErrSudoersDrift = errors.New("sudoers file drift")
+
+// CheckSudoers checks the installed sudoers file of instUser without the root privilege.
+// The mode and the owner are always checked.
+// The content is checked only when the file is readable (e.g., when running as root).
+// See also [VerifySudoersCmd].
+func CheckSudoers(instUser string, policy *Policy, backend Backend) error {
+	sudoersPath, err := SudoersPath(instUser)
+	if err != nil {
+		return err
+	}
+	expected, err := RenderSudoers(instUser, lookupUID(instUser), policy, backend)
+	if err != nil {
+		return err
+	}
+	return checkSudoers(sudoersPath, expected, 0, 0)
+}
+
+func checkSudoers(sudoersPath string, expected []byte, uid, gid uint32) error {
+	st, err := os.Lstat(sudoersPath)
+	if err != nil {
+		return err
+	}
+	var drifts []string
+	if !st.Mode().IsRegular() {
+		drifts = append(drifts, fmt.Sprintf("not a regular file (%v)", st.Mode().Type()))
+	}
+	if perm := st.Mode().Perm(); perm != SudoersMode {
+		drifts = append(drifts, fmt.Sprintf("mode %o (expected %o)", perm, SudoersMode))
+	}
+	if sys, ok := st.Sys().(*syscall.Stat_t); ok && (sys.Uid != uid || sys.Gid != gid) {
+		drifts = append(drifts, fmt.Sprintf("owner %d:%d (expected %d:%d)", sys.Uid, sys.Gid, uid, gid))
+	}
+	if len(drifts) == 0 {
+		b, err := os.ReadFile(sudoersPath)
+		switch {
+		case errors.Is(err, fs.ErrPermission):
+			// Not readable without the root privilege
+		case err != nil:
+			return err
+		case !bytes.Equal(b, expected):
+			drifts = append(drifts, "unexpected content")
+		}
+	}
+	if len(drifts) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrSudoersDrift, sudoersPath, strings.Join(drifts, ", "))
+	}
+	return nil
+}
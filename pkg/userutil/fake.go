@@ -0,0 +1,185 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package userutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/procutil"
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+)
+
+// Fake is a [Backend] for unit tests.
+//
+// The steps returned by AddUserSteps and DeleteUserSteps append the changes to a journal file,
+// and the changes are applied when the other methods read the journal.
+// So the fake is not mutated by the steps that are not executed, e.g., in the dry-run mode.
+type Fake struct {
+	mu      sync.Mutex
+	journal string
+	applied int // the number of the applied journal lines
+	users   map[string]*user.User
+	attrs   map[string]map[Attribute]string
+	nextUID int
+	// HomeBase is the parent of the home directories. Defaults to "/home".
+	HomeBase string
+}
+
+// NewFake returns a [Fake] with no user.
+// The journal of the steps is created in dir.
+func NewFake(dir string) *Fake {
+	return &Fake{
+		journal: filepath.Join(dir, "userutil-fake.journal"),
+		users:   make(map[string]*user.User),
+		attrs:   make(map[string]map[Attribute]string),
+		nextUID: 1000,
+	}
+}
+
+// Add adds a user to the fake.
+func (f *Fake) Add(username string) *user.User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.add(username)
+}
+
+func (f *Fake) add(username string) *user.User {
+	if u, ok := f.users[username]; ok {
+		return u
+	}
+	homeBase := f.HomeBase
+	if homeBase == "" {
+		homeBase = "/home"
+	}
+	uid := strconv.Itoa(f.nextUID)
+	f.nextUID++
+	u := &user.User{Username: username, Uid: uid, Gid: uid, Name: username, HomeDir: filepath.Join(homeBase, username)}
+	f.users[username] = u
+	f.attrs[username] = map[Attribute]string{AttributeUserShell: "/bin/sh"}
+	return u
+}
+
+// replay applies the journal lines appended by the executed steps.
+func (f *Fake) replay() error {
+	b, err := os.ReadFile(f.journal)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	for ; f.applied < len(lines); f.applied++ {
+		op, username, ok := strings.Cut(lines[f.applied], " ")
+		if !ok {
+			return fmt.Errorf("invalid journal line %q", lines[f.applied])
+		}
+		switch op {
+		case "add":
+			f.add(username)
+		case "delete":
+			delete(f.users, username)
+			delete(f.attrs, username)
+		default:
+			return fmt.Errorf("invalid journal line %q", lines[f.applied])
+		}
+	}
+	return nil
+}
+
+// step returns the step that appends the op to the journal.
+func (f *Fake) step(ctx context.Context, description, op, username string) cmdutil.Step {
+	return cmdutil.Step{
+		Description: description,
+		Cmd:         exec.CommandContext(ctx, "sh", "-c", `echo "$1 $2" >>"$0"`, f.journal, op, username),
+	}
+}
+
+func (f *Fake) Users(context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.replay(); err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(f.users)), nil
+}
+
+func (f *Fake) Lookup(_ context.Context, username string) (*user.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.replay(); err != nil {
+		return nil, err
+	}
+	u, ok := f.users[username]
+	if !ok {
+		return nil, user.UnknownUserError(username)
+	}
+	uCopy := *u
+	return &uCopy, nil
+}
+
+func (f *Fake) ReadAttribute(_ context.Context, username string, k Attribute) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.replay(); err != nil {
+		return "", err
+	}
+	attrs, ok := f.attrs[username]
+	if !ok {
+		return "", user.UnknownUserError(username)
+	}
+	v, ok := attrs[k]
+	if !ok {
+		return "", fmt.Errorf("user %q does not have the attribute %q", username, k)
+	}
+	return v, nil
+}
+
+func (f *Fake) AddUserSteps(ctx context.Context, instUser string, _ bool, _ *sudo.Policy, _ sudo.Backend) ([]cmdutil.Step, error) {
+	return []cmdutil.Step{
+		f.step(ctx, fmt.Sprintf("Create the user %q", instUser), "add", instUser),
+	}, nil
+}
+
+func (f *Fake) DeleteUserSteps(ctx context.Context, instUser string, _ bool) ([]cmdutil.Step, error) {
+	return []cmdutil.Step{
+		f.step(ctx, fmt.Sprintf("Delete the user %q", instUser), "delete", instUser),
+	}, nil
+}
+
+// Processes returns no process, as the fake user cannot run a process.
+func (f *Fake) Processes(context.Context, string) ([]procutil.Process, error) {
+	return nil, nil
+}
+
+// CheckSetup only checks that the user exists, as the fake has no home directory and no sudoers file.
+func (f *Fake) CheckSetup(ctx context.Context, instUser string, _ *sudo.Policy, _ sudo.Backend) error {
+	_, err := f.Lookup(ctx, instUser)
+	return err
+}
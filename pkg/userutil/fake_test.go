@@ -0,0 +1,94 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package userutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"os/user"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+)
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake(t.TempDir())
+	orig := Default
+	Default = fake
+	t.Cleanup(func() { Default = orig })
+
+	instUser := UserFromInstance("default")
+	exists, err := Exists(instUser)
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+
+	steps, err := AddUserSteps(ctx, instUser, false, nil, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, len(steps) > 0)
+	// Not applied until the steps are executed
+	exists, err = Exists(instUser)
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+	assert.NilError(t, cmdutil.RunSteps(ctx, steps, nil))
+	exists, err = Exists(instUser)
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+	users, err := Users(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{instUser}, users)
+	shell, err := ReadAttribute(ctx, instUser, AttributeUserShell)
+	assert.NilError(t, err)
+	assert.Equal(t, "/bin/sh", shell)
+	assert.NilError(t, CheckSetup(ctx, instUser, nil, nil))
+
+	steps, err = DeleteUserSteps(ctx, instUser, false)
+	assert.NilError(t, err)
+	assert.NilError(t, cmdutil.RunSteps(ctx, steps, nil))
+	_, err = Lookup(ctx, instUser)
+	var uee user.UnknownUserError
+	assert.Assert(t, errors.As(err, &uee))
+	assert.Assert(t, errors.As(CheckSetup(ctx, instUser, nil, nil), &uee))
+}
+
+func TestFakeNotExecuted(t *testing.T) {
+	fake := NewFake(t.TempDir())
+	instUser := UserFromInstance("default")
+
+	dryRunCtx, err := cmdutil.WithDryRun(context.Background(), io.Discard, cmdutil.DryRunText)
+	assert.NilError(t, err)
+	steps, err := fake.AddUserSteps(dryRunCtx, instUser, false, nil, nil)
+	assert.NilError(t, err)
+	assert.NilError(t, cmdutil.RunSteps(dryRunCtx, steps, nil))
+	assert.NilError(t, cmdutil.WriteScript(io.Discard, "", steps))
+	_, err = fake.Lookup(dryRunCtx, instUser)
+	var uee user.UnknownUserError
+	assert.Assert(t, errors.As(err, &uee))
+
+	ctx := context.Background()
+	steps, err = fake.AddUserSteps(ctx, instUser, false, nil, nil)
+	assert.NilError(t, err)
+	failing := cmdutil.Step{Description: "Fail", Cmd: exec.CommandContext(ctx, "false")}
+	err = cmdutil.RunSteps(ctx, append([]cmdutil.Step{failing}, steps...), nil)
+	assert.ErrorContains(t, err, "Fail")
+	_, err = fake.Lookup(ctx, instUser)
+	assert.Assert(t, errors.As(err, &uee))
+}
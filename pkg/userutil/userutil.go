@@ -17,15 +17,83 @@
 package userutil
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"os"
 	"os/user"
 	"strings"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/procutil"
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+)
+
+type Attribute string
+
+const (
+	AttributeUserShell = Attribute("UserShell")
 )
 
+// Backend is the backend of the user accounts.
+type Backend interface {
+	// Users lists the names of the users.
+	Users(ctx context.Context) ([]string, error)
+	// Lookup looks up the user.
+	// [user.UnknownUserError] is returned if the user does not exist.
+	Lookup(ctx context.Context, username string) (*user.User, error)
+	// ReadAttribute reads the attribute of the user.
+	ReadAttribute(ctx context.Context, username string, k Attribute) (string, error)
+	// AddUserSteps returns the privileged steps to create instUser, including the sudoers setup.
+	// tty specifies whether the password can be prompted.
+	AddUserSteps(ctx context.Context, instUser string, tty bool, policy *sudo.Policy, backend sudo.Backend) ([]cmdutil.Step, error)
+	// DeleteUserSteps returns the privileged steps to delete instUser, including the sudoers setup.
+	DeleteUserSteps(ctx context.Context, instUser string, secure bool) ([]cmdutil.Step, error)
+	// CheckSetup checks that the setup of instUser has been completed.
+	CheckSetup(ctx context.Context, instUser string, policy *sudo.Policy, backend sudo.Backend) error
+	// Processes lists the processes of the user.
+	Processes(ctx context.Context, username string) ([]procutil.Process, error)
+}
+
+// Default is the backend for the current platform.
+// Replaced with [Fake] in tests.
+var Default = platformBackend()
+
+func Users(ctx context.Context) ([]string, error) {
+	return Default.Users(ctx)
+}
+
+// Lookup looks up the user with the default backend.
+func Lookup(ctx context.Context, username string) (*user.User, error) {
+	return Default.Lookup(ctx, username)
+}
+
+func ReadAttribute(ctx context.Context, username string, k Attribute) (string, error) {
+	return Default.ReadAttribute(ctx, username, k)
+}
+
+// AddUserSteps returns the privileged steps to create instUser, including the sudoers setup.
+func AddUserSteps(ctx context.Context, instUser string, tty bool, policy *sudo.Policy, backend sudo.Backend) ([]cmdutil.Step, error) {
+	return Default.AddUserSteps(ctx, instUser, tty, policy, backend)
+}
+
+// DeleteUserSteps returns the privileged steps to delete instUser, including the sudoers setup.
+func DeleteUserSteps(ctx context.Context, instUser string, secure bool) ([]cmdutil.Step, error) {
+	return Default.DeleteUserSteps(ctx, instUser, secure)
+}
+
+// Processes lists the processes of the user with the default backend.
+func Processes(ctx context.Context, username string) ([]procutil.Process, error) {
+	return Default.Processes(ctx, username)
+}
+
 // Prefix is the prefix of the user accounts.
-var Prefix = "alcless_" + me() + "_"
+var Prefix = "alcless_" + Me() + "_"
 
-func me() string {
+// Me returns the name of the current user.
+func Me() string {
 	u, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -45,7 +113,7 @@ func InstanceFromUser(username string) string {
 }
 
 func Exists(name string) (bool, error) {
-	if _, err := user.Lookup(name); err != nil {
+	if _, err := Default.Lookup(context.TODO(), name); err != nil {
 		var uee user.UnknownUserError
 		if errors.As(err, &uee) {
 			return false, nil
@@ -54,3 +122,35 @@ func Exists(name string) (bool, error) {
 	}
 	return true, nil
 }
+
+// CheckSetup checks that the setup of instUser has been completed, possibly by a script
+// generated with `alclessctl create --emit-script`.
+func CheckSetup(ctx context.Context, instUser string, policy *sudo.Policy, backend sudo.Backend) error {
+	return Default.CheckSetup(ctx, instUser, policy, backend)
+}
+
+// checkSetup implements [Backend.CheckSetup] for the real user accounts.
+func checkSetup(ctx context.Context, b Backend, instUser string, policy *sudo.Policy, backend sudo.Backend) error {
+	u, err := b.Lookup(ctx, instUser)
+	if err != nil {
+		return err
+	}
+	st, err := os.Stat(u.HomeDir)
+	if err != nil {
+		return fmt.Errorf("the home directory is not accessible: %w", err)
+	}
+	if perm := st.Mode().Perm(); perm&0o055 != 0 {
+		return fmt.Errorf("the home directory %q is accessible from other users (mode %v)", u.HomeDir, perm)
+	}
+	if err = sudo.CheckSudoers(instUser, policy, backend); err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := sudo.Cmd(ctx, instUser, "", "true", nil, sudo.WithPolicy(policy), sudo.WithBackend(backend))
+	cmd.Stderr = &stderr
+	slog.DebugContext(ctx, "Running command", "cmd", cmd.Args)
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run a command as %q without a password (sudoers not installed?): %w (stderr=%q)", instUser, err, stderr.String())
+	}
+	return nil
+}
@@ -23,15 +23,25 @@ import (
 	"fmt"
 	"log/slog"
 	"os/exec"
+	"os/user"
 	"path/filepath"
 	"strings"
 
 	"github.com/sethvargo/go-password/password"
 
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/procutil"
 	"github.com/AkihiroSuda/alcless/pkg/sudo"
 )
 
-func Users(ctx context.Context) ([]string, error) {
+// directoryServices is the [Backend] for macOS, based on dscl and sysadminctl.
+type directoryServices struct{}
+
+func platformBackend() Backend {
+	return directoryServices{}
+}
+
+func (directoryServices) Users(ctx context.Context) ([]string, error) {
 	var stderr bytes.Buffer
 	cmd := exec.CommandContext(ctx, "dscl", ".", "list", "/Users")
 	cmd.Stderr = &stderr
@@ -48,13 +58,11 @@ func Users(ctx context.Context) ([]string, error) {
 	return res, scanner.Err()
 }
 
-type Attribute string
-
-const (
-	AttributeUserShell = Attribute("UserShell")
-)
+func (directoryServices) Lookup(_ context.Context, username string) (*user.User, error) {
+	return user.Lookup(username)
+}
 
-func ReadAttribute(ctx context.Context, username string, k Attribute) (string, error) {
+func (directoryServices) ReadAttribute(ctx context.Context, username string, k Attribute) (string, error) {
 	var stderr bytes.Buffer
 	cmd := exec.CommandContext(ctx, "dscl", ".", "-read", "/Users/"+username, string(k))
 	cmd.Stderr = &stderr
@@ -69,16 +77,11 @@ func ReadAttribute(ctx context.Context, username string, k Attribute) (string, e
 	return s, nil
 }
 
-func AddUserCmds(ctx context.Context, instUser string, tty bool) ([]*exec.Cmd, error) {
-	sudoersContent, err := sudo.Sudoers(instUser)
-	if err != nil {
-		return nil, err
-	}
-	sudoersPath, err := sudo.SudoersPath(instUser)
+func (directoryServices) AddUserSteps(ctx context.Context, instUser string, tty bool, policy *sudo.Policy, backend sudo.Backend) ([]cmdutil.Step, error) {
+	sudoersCmd, err := sudo.InstallSudoersCmd(ctx, instUser, policy, backend)
 	if err != nil {
 		return nil, err
 	}
-	sudoersCmd := fmt.Sprintf("echo '%s' >'%s'", sudoersContent, sudoersPath)
 	pw := "-"
 	if !tty {
 		pw, err := password.Generate(64, 10, 10, false, false)
@@ -87,14 +90,24 @@ func AddUserCmds(ctx context.Context, instUser string, tty bool) ([]*exec.Cmd, e
 		}
 		slog.WarnContext(ctx, "Generated a random password, as tty is not available. THE PASSWORD IS SHOWN IN THIS SCREEN.", "user", instUser, "password", pw)
 	}
-	return []*exec.Cmd{
-		exec.CommandContext(ctx, "sudo", "sysadminctl", "-addUser", instUser, "-password", pw),
-		exec.CommandContext(ctx, "sudo", "chmod", "go-rx", filepath.Join("/Users", instUser)),
-		exec.CommandContext(ctx, "sudo", "sh", "-c", sudoersCmd),
+	home := filepath.Join("/Users", instUser)
+	return []cmdutil.Step{
+		{
+			Description: fmt.Sprintf("Create the user %q", instUser),
+			Cmd:         exec.CommandContext(ctx, "sudo", "sysadminctl", "-addUser", instUser, "-password", pw),
+		},
+		{
+			Description: fmt.Sprintf("Make the home directory %q inaccessible from other users", home),
+			Cmd:         exec.CommandContext(ctx, "sudo", "chmod", "go-rx", home),
+		},
+		{
+			Description: fmt.Sprintf("Allow the current user to run commands as %q without a password", instUser),
+			Cmd:         sudoersCmd,
+		},
 	}, nil
 }
 
-func DeleteUserCmds(ctx context.Context, instUser string, secure bool) ([]*exec.Cmd, error) {
+func (directoryServices) DeleteUserSteps(ctx context.Context, instUser string, secure bool) ([]cmdutil.Step, error) {
 	sudoersPath, err := sudo.SudoersPath(instUser)
 	if err != nil {
 		return nil, err
@@ -103,9 +116,23 @@ func DeleteUserCmds(ctx context.Context, instUser string, secure bool) ([]*exec.
 	if secure {
 		sysadminctlArgs = append(sysadminctlArgs, "-secure")
 	}
-	cmds := []*exec.Cmd{
-		exec.CommandContext(ctx, "sudo", append([]string{"sysadminctl"}, sysadminctlArgs...)...),
-		exec.CommandContext(ctx, "sudo", "rm", "-f", sudoersPath),
+	steps := []cmdutil.Step{
+		{
+			Description: fmt.Sprintf("Delete the user %q and the home directory", instUser),
+			Cmd:         exec.CommandContext(ctx, "sudo", append([]string{"sysadminctl"}, sysadminctlArgs...)...),
+		},
+		{
+			Description: fmt.Sprintf("Remove the sudoers file %q", sudoersPath),
+			Cmd:         exec.CommandContext(ctx, "sudo", "rm", "-f", sudoersPath),
+		},
 	}
-	return cmds, nil
+	return steps, nil
+}
+
+func (b directoryServices) CheckSetup(ctx context.Context, instUser string, policy *sudo.Policy, backend sudo.Backend) error {
+	return checkSetup(ctx, b, instUser, policy, backend)
+}
+
+func (directoryServices) Processes(ctx context.Context, username string) ([]procutil.Process, error) {
+	return procutil.List(ctx, username)
 }
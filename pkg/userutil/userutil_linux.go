@@ -0,0 +1,178 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package userutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/procutil"
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+)
+
+// shadowUtils is the [Backend] for Linux, based on getent, useradd, and userdel.
+type shadowUtils struct{}
+
+func platformBackend() Backend {
+	return shadowUtils{}
+}
+
+// getent runs `getent passwd [NAME]`.
+func getent(ctx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "getent", append([]string{"passwd"}, args...)...)
+	cmd.Stderr = &stderr
+	slog.DebugContext(ctx, "Running command", "cmd", cmd.Args)
+	b, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %v: %w (stderr=%q)", cmd.Args, err, stderr.String())
+	}
+	return b, nil
+}
+
+func (shadowUtils) Users(ctx context.Context) ([]string, error) {
+	b, err := getent(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		u, _, err := parsePasswd(scanner.Text())
+		if err != nil {
+			return res, err
+		}
+		res = append(res, u.Username)
+	}
+	return res, scanner.Err()
+}
+
+func (shadowUtils) Lookup(ctx context.Context, username string) (*user.User, error) {
+	u, _, err := lookupPasswd(ctx, username)
+	return u, err
+}
+
+// lookupPasswd returns the user and the login shell.
+func lookupPasswd(ctx context.Context, username string) (*user.User, string, error) {
+	b, err := getent(ctx, username)
+	if err != nil {
+		// getent exits with 2 when the key is not found
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+			return nil, "", user.UnknownUserError(username)
+		}
+		return nil, "", err
+	}
+	return parsePasswd(strings.TrimSpace(string(b)))
+}
+
+// parsePasswd parses a line of passwd(5), and returns the user and the login shell.
+func parsePasswd(line string) (*user.User, string, error) {
+	fields := strings.Split(line, ":")
+	if len(fields) != 7 {
+		return nil, "", fmt.Errorf("expected 7 fields, got %q", line)
+	}
+	u := &user.User{
+		Username: fields[0],
+		Uid:      fields[2],
+		Gid:      fields[3],
+		Name:     strings.Split(fields[4], ",")[0],
+		HomeDir:  fields[5],
+	}
+	return u, fields[6], nil
+}
+
+func (shadowUtils) ReadAttribute(ctx context.Context, username string, k Attribute) (string, error) {
+	switch k {
+	case AttributeUserShell:
+		_, shell, err := lookupPasswd(ctx, username)
+		return shell, err
+	default:
+		return "", fmt.Errorf("unsupported attribute %q", k)
+	}
+}
+
+// homeDir returns the home directory of a new user.
+func homeDir(instUser string) string {
+	return filepath.Join("/home", instUser)
+}
+
+func (shadowUtils) AddUserSteps(ctx context.Context, instUser string, _ bool, policy *sudo.Policy, backend sudo.Backend) ([]cmdutil.Step, error) {
+	sudoersCmd, err := sudo.InstallSudoersCmd(ctx, instUser, policy, backend)
+	if err != nil {
+		return nil, err
+	}
+	home := homeDir(instUser)
+	return []cmdutil.Step{
+		{
+			// The password is locked, as the user is accessed only via sudo
+			Description: fmt.Sprintf("Create the user %q", instUser),
+			Cmd:         exec.CommandContext(ctx, "sudo", "useradd", "--create-home", "--home-dir", home, "--user-group", "--shell", "/bin/bash", instUser),
+		},
+		{
+			Description: fmt.Sprintf("Make the home directory %q inaccessible from other users", home),
+			Cmd:         exec.CommandContext(ctx, "sudo", "chmod", "go-rx", home),
+		},
+		{
+			Description: fmt.Sprintf("Allow the current user to run commands as %q without a password", instUser),
+			Cmd:         sudoersCmd,
+		},
+	}, nil
+}
+
+func (shadowUtils) DeleteUserSteps(ctx context.Context, instUser string, secure bool) ([]cmdutil.Step, error) {
+	sudoersPath, err := sudo.SudoersPath(instUser)
+	if err != nil {
+		return nil, err
+	}
+	var steps []cmdutil.Step
+	if secure {
+		home := homeDir(instUser)
+		steps = append(steps, cmdutil.Step{
+			Description: fmt.Sprintf("Securely erase the files in the home directory %q", home),
+			Cmd:         exec.CommandContext(ctx, "sudo", "find", home, "-type", "f", "-exec", "shred", "-u", "{}", "+"),
+		})
+	}
+	steps = append(steps,
+		cmdutil.Step{
+			Description: fmt.Sprintf("Delete the user %q and the home directory", instUser),
+			Cmd:         exec.CommandContext(ctx, "sudo", "userdel", "--remove", instUser),
+		},
+		cmdutil.Step{
+			Description: fmt.Sprintf("Remove the sudoers file %q", sudoersPath),
+			Cmd:         exec.CommandContext(ctx, "sudo", "rm", "-f", sudoersPath),
+		},
+	)
+	return steps, nil
+}
+
+func (b shadowUtils) CheckSetup(ctx context.Context, instUser string, policy *sudo.Policy, backend sudo.Backend) error {
+	return checkSetup(ctx, b, instUser, policy, backend)
+}
+
+func (shadowUtils) Processes(ctx context.Context, username string) ([]procutil.Process, error) {
+	return procutil.List(ctx, username)
+}
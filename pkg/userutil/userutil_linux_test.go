@@ -0,0 +1,36 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package userutil
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParsePasswd(t *testing.T) {
+	u, shell, err := parsePasswd("alcless_foo_default:x:1001:1001:Foo,,,:/home/alcless_foo_default:/bin/bash")
+	assert.NilError(t, err)
+	assert.Equal(t, "alcless_foo_default", u.Username)
+	assert.Equal(t, "1001", u.Uid)
+	assert.Equal(t, "Foo", u.Name)
+	assert.Equal(t, "/home/alcless_foo_default", u.HomeDir)
+	assert.Equal(t, "/bin/bash", shell)
+
+	_, _, err = parsePasswd("foo:x:1001")
+	assert.ErrorContains(t, err, "expected 7 fields")
+}
@@ -0,0 +1,64 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !darwin && !linux
+
+package userutil
+
+import (
+	"context"
+	"errors"
+	"os/user"
+
+	"github.com/AkihiroSuda/alcless/pkg/cmdutil"
+	"github.com/AkihiroSuda/alcless/pkg/procutil"
+	"github.com/AkihiroSuda/alcless/pkg/sudo"
+)
+
+// unsupported is the [Backend] for the unsupported platforms.
+type unsupported struct{}
+
+func platformBackend() Backend {
+	return unsupported{}
+}
+
+func (unsupported) Users(context.Context) ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (unsupported) Lookup(_ context.Context, username string) (*user.User, error) {
+	return user.Lookup(username)
+}
+
+func (unsupported) ReadAttribute(context.Context, string, Attribute) (string, error) {
+	return "", errors.ErrUnsupported
+}
+
+func (unsupported) AddUserSteps(context.Context, string, bool, *sudo.Policy, sudo.Backend) ([]cmdutil.Step, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (unsupported) DeleteUserSteps(context.Context, string, bool) ([]cmdutil.Step, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (unsupported) CheckSetup(context.Context, string, *sudo.Policy, sudo.Backend) error {
+	return errors.ErrUnsupported
+}
+
+func (unsupported) Processes(context.Context, string) ([]procutil.Process, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,198 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package workdir maps the host working directories to the guest working directories.
+package workdir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/AkihiroSuda/alcless/pkg/store/dirnames"
+)
+
+// MappingsFile is the name of the file in the instance directory that records the mappings.
+const MappingsFile = "workdirs.json"
+
+// Policy is the policy of mapping the host working directory to the guest working directory.
+//
+// The value is [PolicyMirror], [PolicyWork], or a Go template that is rendered into a path
+// relative to the home of the instance user, e.g., "src/{{.Name}}".
+// The template can refer to .Name (the base name of the host working directory) and .HostWD.
+type Policy string
+
+const (
+	// PolicyMirror mirrors the host path under the home of the instance user,
+	// e.g., /Users/alice/src/foo -> ~/Users/alice/src/foo.
+	PolicyMirror = Policy("mirror")
+	// PolicyWork maps the host path to ~/work/<base name>, without exposing the host path to the instance.
+	PolicyWork = Policy("work")
+)
+
+// DefaultPolicy is the default policy.
+const DefaultPolicy = PolicyMirror
+
+// Validate validates the policy.
+func (p Policy) Validate() error {
+	_, err := p.render("/validate/example")
+	return err
+}
+
+type templateArgs struct {
+	Name   string
+	HostWD string
+}
+
+// render returns the guest working directory relative to the home of the instance user.
+func (p Policy) render(hostWD string) (string, error) {
+	switch p {
+	case "", PolicyMirror:
+		return strings.TrimPrefix(filepath.Clean(hostWD), string(os.PathSeparator)), nil
+	case PolicyWork:
+		return filepath.Join("work", filepath.Base(hostWD)), nil
+	}
+	if !strings.Contains(string(p), "{{") {
+		return "", fmt.Errorf("unknown workdir mapping policy %q (expected %q, %q, or a template like \"src/{{.Name}}\")", p, PolicyMirror, PolicyWork)
+	}
+	tmpl, err := template.New("workdir").Option("missingkey=error").Parse(string(p))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the workdir mapping template %q: %w", p, err)
+	}
+	var sb strings.Builder
+	if err = tmpl.Execute(&sb, templateArgs{Name: filepath.Base(hostWD), HostWD: hostWD}); err != nil {
+		return "", fmt.Errorf("failed to render the workdir mapping template %q: %w", p, err)
+	}
+	rel := filepath.Clean(sb.String())
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("the workdir mapping template %q must be rendered into a relative path without \"..\", got %q", p, rel)
+	}
+	return rel, nil
+}
+
+// Mapping is a recorded mapping.
+type Mapping struct {
+	HostWD string `json:"hostWD"`
+	// GuestWD is relative to the home of the instance user.
+	GuestWD string    `json:"guestWD"`
+	Policy  Policy    `json:"policy"`
+	Updated time.Time `json:"updated"`
+}
+
+// Relative returns the guest working directory relative to the home of the instance user, without recording it.
+func Relative(hostWD string, policy Policy) (string, error) {
+	return policy.render(hostWD)
+}
+
+// Resolve returns the guest working directory relative to the home of the instance user.
+//
+// The mapping is recorded in ~/.alcless/<INSTANCE>/workdirs.json, so that the later sessions with the same policy
+// use the same guest working directory.
+// When the rendered path is already used by another host working directory, a numeric suffix is appended.
+func Resolve(instName, hostWD string, policy Policy) (string, error) {
+	return resolve(instName, hostWD, policy, true)
+}
+
+// Peek returns the guest working directory that [Resolve] would return, without recording the mapping.
+func Peek(instName, hostWD string, policy Policy) (string, error) {
+	return resolve(instName, hostWD, policy, false)
+}
+
+func resolve(instName, hostWD string, policy Policy, record bool) (string, error) {
+	if policy == "" {
+		policy = DefaultPolicy
+	}
+	mappings, err := LoadMappings(instName)
+	if err != nil {
+		return "", err
+	}
+	var others []Mapping
+	for _, m := range mappings {
+		if m.HostWD == hostWD {
+			if m.Policy == policy {
+				return m.GuestWD, nil
+			}
+			continue
+		}
+		others = append(others, m)
+	}
+	base, err := policy.render(hostWD)
+	if err != nil {
+		return "", err
+	}
+	guestWD := base
+	for i := 2; used(others, guestWD); i++ {
+		guestWD = base + "-" + strconv.Itoa(i)
+	}
+	if !record {
+		return guestWD, nil
+	}
+	others = append(others, Mapping{HostWD: hostWD, GuestWD: guestWD, Policy: policy, Updated: time.Now()})
+	if err = saveMappings(instName, others); err != nil {
+		return "", err
+	}
+	return guestWD, nil
+}
+
+func used(mappings []Mapping, guestWD string) bool {
+	for _, m := range mappings {
+		if m.GuestWD == guestWD {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadMappings loads the recorded mappings of the instance.
+func LoadMappings(instName string) ([]Mapping, error) {
+	instDir, err := dirnames.InstanceDir(instName)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(instDir, MappingsFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var mappings []Mapping
+	if err = json.Unmarshal(b, &mappings); err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
+
+func saveMappings(instName string, mappings []Mapping) error {
+	instDir, err := dirnames.InstanceDir(instName)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(instDir, 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(mappings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(instDir, MappingsFile), append(b, '\n'), 0o600)
+}
@@ -0,0 +1,100 @@
+// Copyright The Alcoholless Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package workdir
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestRelative(t *testing.T) {
+	testCases := []struct {
+		policy   Policy
+		hostWD   string
+		expected string
+		err      string
+	}{
+		{"", "/Users/alice/src/foo", "Users/alice/src/foo", ""},
+		{PolicyMirror, "/Users/alice/src/foo", "Users/alice/src/foo", ""},
+		{PolicyWork, "/Users/alice/src/foo", "work/foo", ""},
+		{"src/{{.Name}}", "/Users/alice/src/foo", "src/foo", ""},
+		{"/abs/{{.Name}}", "/Users/alice/src/foo", "", "relative path"},
+		{"../{{.Name}}", "/Users/alice/src/foo", "", "relative path"},
+		{"{{.Unknown}}", "/Users/alice/src/foo", "", "failed to render"},
+		{"unknown", "/Users/alice/src/foo", "", "unknown workdir mapping policy"},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			got, err := Relative(tc.hostWD, tc.policy)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("ALCLESS_HOME", t.TempDir())
+	const inst = "default"
+	got, err := Resolve(inst, "/Users/alice/src/foo", PolicyWork)
+	assert.NilError(t, err)
+	assert.Equal(t, "work/foo", got)
+
+	// Same base name
+	got, err = Resolve(inst, "/Users/alice/tmp/foo", PolicyWork)
+	assert.NilError(t, err)
+	assert.Equal(t, "work/foo-2", got)
+
+	// Recorded
+	got, err = Resolve(inst, "/Users/alice/tmp/foo", PolicyWork)
+	assert.NilError(t, err)
+	assert.Equal(t, "work/foo-2", got)
+	got, err = Resolve(inst, "/Users/alice/src/foo", PolicyWork)
+	assert.NilError(t, err)
+	assert.Equal(t, "work/foo", got)
+
+	// Policy changed
+	got, err = Resolve(inst, "/Users/alice/src/foo", PolicyMirror)
+	assert.NilError(t, err)
+	assert.Equal(t, "Users/alice/src/foo", got)
+	mappings, err := LoadMappings(inst)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(mappings))
+}
+
+func TestPeek(t *testing.T) {
+	t.Setenv("ALCLESS_HOME", t.TempDir())
+	const inst = "default"
+	_, err := Resolve(inst, "/Users/alice/src/foo", PolicyWork)
+	assert.NilError(t, err)
+
+	// Recorded
+	got, err := Peek(inst, "/Users/alice/src/foo", PolicyWork)
+	assert.NilError(t, err)
+	assert.Equal(t, "work/foo", got)
+	// Not recorded
+	got, err = Peek(inst, "/Users/alice/tmp/foo", PolicyWork)
+	assert.NilError(t, err)
+	assert.Equal(t, "work/foo-2", got)
+	mappings, err := LoadMappings(inst)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(mappings))
+}